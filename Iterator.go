@@ -1,7 +1,7 @@
 package bitcask_kv
 
 import (
-	"bitcask-kv/index"	
+	"bitcask-kv/index"
 	"bytes"
 )
 
@@ -14,27 +14,37 @@ type Iterator struct {
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
+	return db.newIterator(db.index, opts)
+}
+
+// 基于指定的索引初始化迭代器
+func (db *DB) newIterator(idx index.Indexer, opts IteratorOptions) *Iterator {
+	indexIter := idx.Iterator(opts.Reverse)
+	it := &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		options:   opts,
 	}
+	it.skipToNext()
+	return it
 }
 
 // Rewind 重新回到迭代器的起点，即第一个数据
 func (it *Iterator) Rewind() {
 	it.indexIter.Rewind()
+	it.skipToNext()
 }
 
 // Seek 根据传入的 key 查找第一个大于(小于)等于的目标 key，根据从这个 key 开始遍历
 func (it *Iterator) Seek(key []byte) {
 	it.indexIter.Seek(key)
+	it.skipToNext()
 }
 
 // Next 跳转到下一个 key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
+	it.skipToNext()
 }
 
 // Valid 是否有效，即是否已经遍历完所有的 key，用于退出遍历
@@ -55,15 +65,15 @@ func (it *Iterator) Value() ([]byte, error) {
 	return it.db.getValueByPosition(logRecordPos)
 }
 
+// 跳过不满足前缀条件以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
-
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired() {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if prefixLen == 0 || prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
 			break
 		}
 	}
@@ -0,0 +1,149 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BackupIncremental 在 dir 目录中创建增量备份，只包含备份 since 之后新创建或者被 merge 重写的文件
+// since 为上一次通过 Checkpoint 创建的全量备份或者增量备份的目录，为空时创建全量备份。
+// 增量备份通过目录名称引用之前的备份中的文件，同一条备份链中的所有备份需要放在同一个父目录下
+func (db *DB) BackupIncremental(dir string, since string) error {
+	if since == "" {
+		return db.Checkpoint(dir)
+	}
+
+	dirAbs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	sinceAbs, err := filepath.Abs(since)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(dirAbs) != filepath.Dir(sinceAbs) {
+		return ErrBackupNotSibling
+	}
+
+	prev, err := ReadCheckpointManifest(since)
+	if err != nil {
+		return err
+	}
+	return db.createCheckpoint(dir, prev, filepath.Base(sinceAbs))
+}
+
+// Restore 将全量备份或者增量备份 backupDir 恢复为完整的数据目录 targetDir，targetDir 必须不存在
+// 增量备份引用的文件从同一个父目录下之前的备份中拷贝，拷贝之后校验每一条日志记录的 CRC，
+// 文件缺失或者校验失败时返回 ErrBackupCorrupted，并删除恢复了一部分的目录
+func Restore(backupDir, targetDir string) error {
+	manifest, err := ReadCheckpointManifest(backupDir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(targetDir); err == nil {
+		return ErrRestoreDirExists
+	}
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := restoreFiles(backupDir, targetDir, manifest); err != nil {
+		_ = os.RemoveAll(targetDir)
+		return err
+	}
+	return nil
+}
+
+func restoreFiles(backupDir, targetDir string, manifest *CheckpointManifest) error {
+	parentDir := filepath.Dir(backupDir)
+
+	// 校验备份链中被引用的备份都是完整的
+	for name := range backupChain(manifest) {
+		if _, err := ReadCheckpointManifest(filepath.Join(parentDir, name)); err != nil {
+			return fmt.Errorf("%w: backup %s is unavailable: %v", ErrBackupCorrupted, name, err)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		srcDir := backupDir
+		if file.Backup != "" {
+			srcDir = filepath.Join(parentDir, file.Backup)
+		}
+		srcName := filepath.Join(srcDir, file.Name)
+		info, err := os.Stat(srcName)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("%w: the size of %s is %d, expected %d", ErrBackupCorrupted, srcName, info.Size(), file.Size)
+		}
+
+		if err := utils.CopyFile(srcName, filepath.Join(targetDir, file.Name)); err != nil {
+			return err
+		}
+		if err := verifyLogFile(targetDir, file.Name); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrBackupCorrupted, srcName, err)
+		}
+	}
+
+	return writeSeqNoFile(targetDir, manifest.SeqNo)
+}
+
+// 增量备份引用的之前的备份名称
+func backupChain(manifest *CheckpointManifest) map[string]struct{} {
+	chain := make(map[string]struct{})
+	for _, file := range manifest.Files {
+		if file.Backup != "" {
+			chain[file.Backup] = struct{}{}
+		}
+	}
+	return chain
+}
+
+// 顺序读取文件中所有的日志记录并校验 CRC，文件末尾不能有无法解析的数据
+// 数据文件、Hint 文件、merge 完成标识文件和事务序列号文件都由日志记录组成，其他文件不做校验
+func verifyLogFile(dirPath string, name string) error {
+	var logFile *data.DataFile
+	var err error
+	if fid, ok := parseDataFileName(name); ok {
+		logFile, err = data.OpenDataFile(dirPath, fid, fio.StandardIO)
+	} else if name == data.HintFileName {
+		logFile, err = data.OpenHintFile(dirPath)
+	} else if name == data.MergeFinishedFileName {
+		logFile, err = data.OpenMergeFinishedFile(dirPath)
+	} else if name == data.SeqNoFileName {
+		logFile, err = data.OpenSeqNoFile(dirPath)
+	} else {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	fileSize, err := logFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for {
+		_, size, err := logFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("offset %d: %v", offset, err)
+		}
+		offset += size
+	}
+	if offset != fileSize {
+		return fmt.Errorf("offset %d: %d bytes of trailing data can not be decoded", offset, fileSize-offset)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package bitcask_kv
+
+import (
+	"archive/tar"
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/index"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 清单文件的最大长度，避免读取损坏的备份流时占用过多内存
+const maxBackupManifestSize = 64 * 1024 * 1024
+
+// BackupTo 将数据库当前状态的一致性备份以 tar 格式写入到 w 中，可以通过 opts 配置 gzip 压缩
+// 备份流中包含数据文件、Hint 文件、merge 完成标识文件、事务序列号文件和 B+ 树索引文件，最后是清单文件。
+// 和 Checkpoint 一样只在切换活跃文件时短暂地持有锁，写入 w 时不会阻塞数据库的读写
+func (db *DB) BackupTo(w io.Writer, opts BackupOptions) error {
+	// B+ 树索引文件会被修改，持有锁时只获取快照，释放锁之后再拷贝到临时文件中
+	var indexSnap *index.BPTreeSnapshot
+	files, err := db.sealFiles(func() error {
+		bpt, ok := db.index.(*index.BPlusTree)
+		if !ok {
+			return nil
+		}
+		var err error
+		indexSnap, err = bpt.Snapshot()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var indexFile *os.File
+	if indexSnap != nil {
+		if indexFile, err = copyIndexSnapshot(indexSnap); err != nil {
+			return err
+		}
+		defer func() {
+			_ = indexFile.Close()
+			_ = os.Remove(indexFile.Name())
+		}()
+	}
+	manifest := &CheckpointManifest{
+		SeqNo:         files.seqNo,
+		CreatedAt:     time.Now(),
+		MergeBoundary: files.mergeBoundary,
+	}
+
+	var gzipWriter *gzip.Writer
+	out := w
+	if opts.Compress {
+		gzipWriter = gzip.NewWriter(w)
+		out = gzipWriter
+	}
+	tw := tar.NewWriter(out)
+
+	if indexFile != nil {
+		fileInfo, err := indexFile.Stat()
+		if err != nil {
+			return err
+		}
+		size := fileInfo.Size()
+		if err := writeTarFile(tw, index.BPTreeIndexFileName, size, indexFile); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: index.BPTreeIndexFileName, Size: size})
+	}
+
+	for _, name := range files.fileNames {
+		size, err := writeTarFileFromDisk(tw, db.options.DirPath, name)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: name, Size: size})
+	}
+
+	if files.activeFileName != "" {
+		if err := writeTarFile(tw, files.activeFileName, 0, bytes.NewReader(nil)); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: files.activeFileName})
+	}
+
+	seqNoBuf := encodeSeqNo(files.seqNo)
+	if err := writeTarFile(tw, data.SeqNoFileName, int64(len(seqNoBuf)), bytes.NewReader(seqNoBuf)); err != nil {
+		return err
+	}
+
+	// 清单文件最后写入，恢复时据此判断备份流是否完整
+	manifestBuf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, CheckpointManifestName, int64(len(manifestBuf)), bytes.NewReader(manifestBuf)); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gzipWriter != nil {
+		return gzipWriter.Close()
+	}
+	return nil
+}
+
+// 将 B+ 树索引的快照拷贝到临时文件中并关闭快照，返回从头开始读取的临时文件
+// 快照会阻塞索引文件扩容，拷贝到本地的临时文件可以尽快关闭，不受写入备份流的速度影响
+func copyIndexSnapshot(snap *index.BPTreeSnapshot) (*os.File, error) {
+	defer snap.Close()
+	file, err := os.CreateTemp("", "bitcask-go-backup-index")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := snap.WriteTo(file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     fio.DataFilePerm,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// 将数据目录中不会再被修改的文件写入到备份流中，返回文件的大小
+func writeTarFileFromDisk(tw *tar.Writer, dirPath string, name string) (int64, error) {
+	file, err := os.Open(filepath.Join(dirPath, name))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), writeTarFile(tw, name, info.Size(), file)
+}
+
+// RestoreFrom 将 BackupTo 写入的备份流恢复到数据目录 dir 中，dir 必须不存在，自动识别 gzip 压缩
+// 备份流不完整、包含未知的文件或者日志记录的 CRC 校验失败时返回 ErrBackupCorrupted，并删除恢复了一部分的目录
+func RestoreFrom(r io.Reader, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return ErrRestoreDirExists
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := restoreFromTar(r, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func restoreFromTar(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	// gzip 格式的魔数
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+		}
+		defer gzipReader.Close()
+		src = gzipReader
+	}
+
+	var manifest *CheckpointManifest
+	restored := make(map[string]int64)
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+		}
+		if manifest != nil {
+			return fmt.Errorf("%w: unexpected entry %s after the manifest", ErrBackupCorrupted, header.Name)
+		}
+		if header.Typeflag != tar.TypeReg || !isBackupFileName(header.Name) {
+			return fmt.Errorf("%w: unexpected entry %s", ErrBackupCorrupted, header.Name)
+		}
+
+		if header.Name == CheckpointManifestName {
+			buf, err := io.ReadAll(io.LimitReader(tr, maxBackupManifestSize))
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+			}
+			manifest = &CheckpointManifest{}
+			if err := json.Unmarshal(buf, manifest); err != nil {
+				return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+			}
+			continue
+		}
+
+		size, err := restoreTarFile(tr, dir, header.Name)
+		if err != nil {
+			return err
+		}
+		restored[header.Name] = size
+	}
+	if manifest == nil {
+		return fmt.Errorf("%w: the manifest is missing, the backup stream may be truncated", ErrBackupCorrupted)
+	}
+	// 读取到 gzip 流的末尾，校验 gzip 的校验和
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+	}
+
+	for _, file := range manifest.Files {
+		size, ok := restored[file.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrBackupCorrupted, file.Name)
+		}
+		if size != file.Size {
+			return fmt.Errorf("%w: the size of %s is %d, expected %d", ErrBackupCorrupted, file.Name, size, file.Size)
+		}
+		delete(restored, file.Name)
+	}
+	delete(restored, data.SeqNoFileName)
+	for name := range restored {
+		return fmt.Errorf("%w: %s is not in the manifest", ErrBackupCorrupted, name)
+	}
+
+	for _, file := range manifest.Files {
+		if err := verifyLogFile(dir, file.Name); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrBackupCorrupted, file.Name, err)
+		}
+	}
+	if err := verifyLogFile(dir, data.SeqNoFileName); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrBackupCorrupted, data.SeqNoFileName, err)
+	}
+	return nil
+}
+
+// 将备份流中的一个文件写入到数据目录中，返回文件的大小
+func restoreTarFile(r io.Reader, dir string, name string) (int64, error) {
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		if os.IsExist(err) {
+			return 0, fmt.Errorf("%w: duplicate entry %s", ErrBackupCorrupted, name)
+		}
+		return 0, err
+	}
+	size, err := io.Copy(file, r)
+	if err != nil {
+		_ = file.Close()
+		return 0, fmt.Errorf("%w: %v", ErrBackupCorrupted, err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	return size, file.Close()
+}
+
+// 备份流中允许出现的文件，不能包含目录，避免写入到数据目录之外
+func isBackupFileName(name string) bool {
+	if name != filepath.Base(name) {
+		return false
+	}
+	if _, ok := parseDataFileName(name); ok {
+		return true
+	}
+	switch name {
+	case data.HintFileName, data.MergeFinishedFileName, data.SeqNoFileName,
+		index.BPTreeIndexFileName, CheckpointManifestName:
+		return true
+	}
+	return false
+}
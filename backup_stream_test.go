@@ -0,0 +1,123 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BackupTo(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-to")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+
+	restoreRoot, _ := os.MkdirTemp("", "bitcask-go-restore-from")
+	defer os.RemoveAll(restoreRoot)
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		assert.Nil(t, db.BackupTo(&buf, BackupOptions{Compress: compress}))
+		if compress {
+			assert.Equal(t, []byte{0x1f, 0x8b}, buf.Bytes()[:2])
+		}
+		backup := buf.Bytes()
+
+		restoreDir := filepath.Join(restoreRoot, "restore")
+		assert.Nil(t, RestoreFrom(bytes.NewReader(backup), restoreDir))
+		assert.Equal(t, ErrRestoreDirExists, RestoreFrom(bytes.NewReader(backup), restoreDir))
+
+		restoreOpts := opts
+		restoreOpts.DirPath = restoreDir
+		restored, err := Open(restoreOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 501, len(restored.ListKeys()))
+		val, err := restored.Get(utils.GetTestKey(1000))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch"), val)
+		assert.Equal(t, uint64(1), restored.seqNo)
+		assert.Nil(t, restored.Close())
+		assert.Nil(t, os.RemoveAll(restoreDir))
+
+		// 不完整的备份流
+		err = RestoreFrom(bytes.NewReader(backup[:len(backup)/2]), restoreDir)
+		assert.True(t, errors.Is(err, ErrBackupCorrupted))
+		_, err = os.Stat(restoreDir)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// 数据文件中的内容损坏
+	var buf bytes.Buffer
+	assert.Nil(t, db.BackupTo(&buf, DefaultBackupOptions))
+	backup := buf.Bytes()
+	copy(backup[1024:], []byte("corrupted"))
+	err = RestoreFrom(bytes.NewReader(backup), filepath.Join(restoreRoot, "corrupted"))
+	assert.True(t, errors.Is(err, ErrBackupCorrupted))
+}
+
+// 第一次写入时调用 fn 的 io.Writer
+type onWriteWriter struct {
+	w  io.Writer
+	fn func()
+}
+
+func (w *onWriteWriter) Write(p []byte) (int, error) {
+	if w.fn != nil {
+		w.fn()
+		w.fn = nil
+	}
+	return w.w.Write(p)
+}
+
+func TestDB_BackupTo_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-to-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 写入备份流时没有持有锁，之后的写入不会出现在备份中
+	var buf bytes.Buffer
+	w := &onWriteWriter{w: &buf, fn: func() {
+		assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("after backup")))
+	}}
+	assert.Nil(t, db.BackupTo(w, DefaultBackupOptions))
+
+	restoreRoot, _ := os.MkdirTemp("", "bitcask-go-restore-from-bptree")
+	defer os.RemoveAll(restoreRoot)
+	restoreOpts := opts
+	restoreOpts.DirPath = filepath.Join(restoreRoot, "restore")
+	assert.Nil(t, RestoreFrom(&buf, restoreOpts.DirPath))
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	defer restored.Close()
+	assert.Equal(t, 500, len(restored.ListKeys()))
+	_, err = restored.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after backup"), val)
+}
@@ -0,0 +1,101 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BackupIncremental(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-incr")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	backupRoot, _ := os.MkdirTemp("", "bitcask-go-backup-root")
+	defer os.RemoveAll(backupRoot)
+	base := filepath.Join(backupRoot, "base")
+	incr1 := filepath.Join(backupRoot, "incr-1")
+	incr2 := filepath.Join(backupRoot, "incr-2")
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.BackupIncremental(base, ""))
+
+	// 增量备份只包含新的数据文件
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Equal(t, ErrBackupNotSibling, db.BackupIncremental(filepath.Join(dir, "incr"), base))
+	assert.Nil(t, db.BackupIncremental(incr1, base))
+	manifest, err := ReadCheckpointManifest(incr1)
+	assert.Nil(t, err)
+	assert.Equal(t, "base", manifest.Parent)
+	var reused int
+	for _, file := range manifest.Files {
+		if file.Backup == "base" {
+			reused++
+		}
+	}
+	assert.True(t, reused > 0)
+
+	// merge 之后重写的数据文件需要重新备份
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(2000), []byte("latest")))
+	assert.Nil(t, db.BackupIncremental(incr2, incr1))
+
+	restoreDir := filepath.Join(backupRoot, "restore-2")
+	assert.Nil(t, Restore(incr2, restoreDir))
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	restored, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, len(restored.ListKeys()))
+	val, err := restored.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("latest"), val)
+	assert.Nil(t, restored.Close())
+
+	// 恢复中间的增量备份
+	restoreDir = filepath.Join(backupRoot, "restore-1")
+	assert.Nil(t, Restore(incr1, restoreDir))
+	restoreOpts.DirPath = restoreDir
+	restored, err = Open(restoreOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(restored.ListKeys()))
+	assert.Nil(t, restored.Close())
+	assert.Equal(t, ErrRestoreDirExists, Restore(incr1, restoreDir))
+
+	// 基础备份中的文件损坏之后恢复失败
+	assert.Nil(t, db.Close())
+	for _, file := range manifest.Files {
+		if file.Backup == "base" {
+			f, err := os.OpenFile(filepath.Join(base, file.Name), os.O_WRONLY, 0644)
+			assert.Nil(t, err)
+			_, err = f.WriteAt([]byte("corrupted"), 20)
+			assert.Nil(t, err)
+			assert.Nil(t, f.Close())
+			break
+		}
+	}
+	restoreDir = filepath.Join(backupRoot, "restore-corrupted")
+	err = Restore(incr1, restoreDir)
+	assert.True(t, errors.Is(err, ErrBackupCorrupted))
+	_, err = os.Stat(restoreDir)
+	assert.True(t, os.IsNotExist(err))
+}
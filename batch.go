@@ -8,13 +8,30 @@ import (
 )
 
 var txnFinKey = []byte("txn-fin")
+
 const nonTransactionSeqNo uint64 = 0
+
 // 原子批量写数据，保证原子性
 type WriteBatch struct {
 	options       WriteBatchOptions
 	mtx           *sync.Mutex
 	db            *DB
 	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	conditions    []*batchCondition          // 提交时需要满足的前置条件
+	savepoints    []*batchSavepoint          // 保存点，后设置的在栈顶
+}
+
+// 批量写的保存点，记录设置时暂存数据的副本
+type batchSavepoint struct {
+	pendingWrites map[string]*data.LogRecord
+	conditionNum  int
+}
+
+// 批量写的前置条件，在 Commit 时和写入操作在同一把锁内校验
+type batchCondition struct {
+	key      []byte
+	expected []byte // 期望的当前值
+	absent   bool   // 期望 key 不存在
 }
 
 // NewWriteBatch 初始化一个 WriteBatch
@@ -44,6 +61,18 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	return nil
 }
 
+// 批量写入带有过期时间的数据，expire 为 UnixNano 时间戳
+func (wb *WriteBatch) putWithExpire(key []byte, value []byte, expire int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value, Expire: expire}
+	return nil
+}
+
 // Delete 删除数据
 func (wb *WriteBatch) Delete(key []byte) error {
 	if len(key) == 0 {
@@ -67,6 +96,148 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	return nil
 }
 
+// Get 读取数据，优先读取批次中暂存的数据，其次读取数据库
+func (wb *WriteBatch) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	wb.mtx.Lock()
+	if record, ok := wb.pendingWrites[string(key)]; ok {
+		wb.mtx.Unlock()
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+	wb.mtx.Unlock()
+	return wb.db.Get(key)
+}
+
+// Len 批次中暂存的数据条数
+func (wb *WriteBatch) Len() int {
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+	return len(wb.pendingWrites)
+}
+
+// ByteSize 批次中暂存的 key 和 value 的总字节数
+func (wb *WriteBatch) ByteSize() int64 {
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+	return wb.byteSize()
+}
+
+func (wb *WriteBatch) byteSize() int64 {
+	var size int64
+	for _, record := range wb.pendingWrites {
+		size += int64(len(record.Key) + len(record.Value))
+	}
+	return size
+}
+
+// Rollback 丢弃批次中暂存的所有数据
+func (wb *WriteBatch) Rollback() {
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.conditions = nil
+	wb.savepoints = nil
+}
+
+// SetSavepoint 设置一个保存点，之后可以通过 RollbackToSavepoint 回滚到此处
+func (wb *WriteBatch) SetSavepoint() {
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	pendingWrites := make(map[string]*data.LogRecord, len(wb.pendingWrites))
+	for key, record := range wb.pendingWrites {
+		pendingWrites[key] = record
+	}
+	wb.savepoints = append(wb.savepoints, &batchSavepoint{
+		pendingWrites: pendingWrites,
+		conditionNum:  len(wb.conditions),
+	})
+}
+
+// RollbackToSavepoint 回滚到最近一次设置的保存点，并移除该保存点
+func (wb *WriteBatch) RollbackToSavepoint() error {
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	if len(wb.savepoints) == 0 {
+		return ErrNoSavepoint
+	}
+	sp := wb.savepoints[len(wb.savepoints)-1]
+	wb.savepoints = wb.savepoints[:len(wb.savepoints)-1]
+	wb.pendingWrites = sp.pendingWrites
+	wb.conditions = wb.conditions[:sp.conditionNum]
+	return nil
+}
+
+// PutIfAbsent 批量写数据，提交时要求 key 不存在，否则整个批次提交失败
+func (wb *WriteBatch) PutIfAbsent(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, absent: true})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// CompareAndSwap 批量写数据，提交时要求 key 的当前值等于 oldValue，否则整个批次提交失败
+func (wb *WriteBatch) CompareAndSwap(key []byte, oldValue, newValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, expected: oldValue})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: newValue}
+	return nil
+}
+
+// CompareAndDelete 删除数据，提交时要求 key 的当前值等于 oldValue，否则整个批次提交失败
+func (wb *WriteBatch) CompareAndDelete(key []byte, oldValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, expected: oldValue})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// 校验所有的前置条件
+// 在访问此方法前必须持有 DB 的互斥锁
+func (wb *WriteBatch) checkConditions() error {
+	for _, cond := range wb.conditions {
+		if cond.absent {
+			_, err := wb.db.getValue(cond.key)
+			if err == nil {
+				return ErrBatchConditionFailed
+			}
+			if err != ErrKeyNotFound {
+				return err
+			}
+			continue
+		}
+		matched, err := wb.db.valueEquals(cond.key, cond.expected)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return ErrBatchConditionFailed
+		}
+	}
+	return nil
+}
+
 // Commit 提交事务，将暂存的数据写到数据文件，并更新到内存索引
 func (wb *WriteBatch) Commit() error {
 	wb.mtx.Lock()
@@ -79,64 +250,134 @@ func (wb *WriteBatch) Commit() error {
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
+	if wb.options.MaxBatchBytes > 0 && wb.byteSize() > wb.options.MaxBatchBytes {
+		return ErrExceedMaxBatchBytes
+	}
 
 	// 对 DB 实例加锁，串行化实现隔离性
 	wb.db.mtx.Lock()
 	defer wb.db.mtx.Unlock()
 
+	// 前置条件不满足则放弃提交，不写入任何数据
+	if err := wb.checkConditions(); err != nil {
+		return err
+	}
+
+	if err := wb.db.commitPendingWrites(wb.pendingWrites, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// 清空暂存的数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.conditions = nil
+	wb.savepoints = nil
+
+	return nil
+}
+
+// 以事务的方式写入暂存的数据，并更新到内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) commitPendingWrites(pendingWrites map[string]*data.LogRecord, syncWrites bool) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
 	// 获取到当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	commitSeq, commitTime := db.nextCommit()
 
 	// 开始写数据到数据文件中
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithReq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
-		if err != nil {
+	records := encodeTxnRecords(pendingWrites, seqNo, commitSeq, commitTime)
+	positions, err := db.writeTxnRecords(records)
+	if err != nil {
+		return err
+	}
+
+	// 根据配置决定是否持久化
+	if syncWrites && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+	}
+
+	db.publishPendingWrites(pendingWrites, positions)
+	return nil
+}
+
+// 编码之后等待写入数据文件的一条事务记录
+type encodedTxnRecord struct {
+	key       []byte // 实际的 key，事务完成记录为空
+	logRecord *data.LogRecord
+	buf       []byte
+}
+
+// 编码事务中的所有记录，最后一条为标识事务完成的记录
+// 编码不依赖数据库的状态，可以在获取互斥锁之前进行
+func encodeTxnRecords(pendingWrites map[string]*data.LogRecord, seqNo, commitSeq uint64, commitTime int64) []*encodedTxnRecord {
+	records := make([]*encodedTxnRecord, 0, len(pendingWrites)+1)
+	for _, record := range pendingWrites {
+		logRecord := &data.LogRecord{
+			Key:        logRecordKeyWithReq(record.Key, seqNo),
+			Value:      record.Value,
+			Type:       record.Type,
+			Expire:     record.Expire,
+			CommitSeq:  commitSeq,
+			CommitTime: commitTime,
+		}
+		buf, _ := data.EncodeLogRecord(logRecord)
+		records = append(records, &encodedTxnRecord{key: record.Key, logRecord: logRecord, buf: buf})
 	}
 
 	// 写一条标识数据完成的数据
 	finishedRecord := &data.LogRecord{
-		Key:  logRecordKeyWithReq(txnFinKey, seqNo),
-		Type: data.LogRecordTxnFinished,
-	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
-		return err
+		Key:        logRecordKeyWithReq(txnFinKey, seqNo),
+		Type:       data.LogRecordTxnFinished,
+		CommitSeq:  commitSeq,
+		CommitTime: commitTime,
 	}
+	buf, _ := data.EncodeLogRecord(finishedRecord)
+	return append(records, &encodedTxnRecord{logRecord: finishedRecord, buf: buf})
+}
 
-	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
+// 将编码好的事务记录追加写入到数据文件中，返回每个 key 的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeTxnRecords(records []*encodedTxnRecord) (map[string]*data.LogRecordPos, error) {
+	positions := make(map[string]*data.LogRecordPos, len(records))
+	for _, record := range records {
+		pos, err := db.appendEncodedLogRecord(record.logRecord, record.buf)
+		if err != nil {
+			return nil, err
+		}
+		if record.key != nil {
+			positions[string(record.key)] = pos
 		}
 	}
+	return positions, nil
+}
+
+// 将已经写入数据文件的事务数据更新到内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) publishPendingWrites(pendingWrites map[string]*data.LogRecord, positions map[string]*data.LogRecordPos) {
+	db.version++
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
+	for _, record := range pendingWrites {
 		pos := positions[string(record.Key)]
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = db.index.Put(record.Key, pos)
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = db.index.Delete(record.Key)
 		}
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			db.reclaimSize += int64(oldPos.Size)
+		}
+		db.markModified(record.Key)
+		if record.Expire > 0 {
+			db.expireQueue.push(record.Key, record.Expire)
 		}
 	}
-
-
-	// 清空暂存的数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
-	return nil
 }
 
 func logRecordKeyWithReq(key []byte, seqNo uint64) []byte {
@@ -150,6 +391,12 @@ func logRecordKeyWithReq(key []byte, seqNo uint64) []byte {
 	return encKey
 }
 
+// ParseLogRecordKey 解析数据文件中日志记录的 key，返回实际的 key 和事务序列号
+// 非事务写入的序列号为 0，用于解析 data.LogReader 读取到的日志记录
+func ParseLogRecordKey(key []byte) ([]byte, uint64) {
+	return parseLogRecordKey(key)
+}
+
 func parseLogRecordKey(key []byte) ([]byte, uint64) {
 	seqNo, n := binary.Uvarint(key)
 	realKey := key[n:]
@@ -106,4 +106,118 @@ func TestDB_WriteBatch3(t *testing.T) {
 	// }
 	// err = wb.Commit()
 	// assert.Nil(t, err)
-}
\ No newline at end of file
+}
+func TestDB_WriteBatch_Conditions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-cond")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	// 前置条件满足，正常提交
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.PutIfAbsent(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	err = wb.CompareAndSwap(utils.GetTestKey(1), []byte("v1"), []byte("v11"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v11"), val)
+
+	// 任意一个前置条件不满足，整个批次都不会写入
+	wb2 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb2.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Nil(t, err)
+	err = wb2.CompareAndDelete(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	err = wb2.PutIfAbsent(utils.GetTestKey(1), []byte("v12"))
+	assert.Nil(t, err)
+	err = wb2.Commit()
+	assert.Equal(t, ErrBatchConditionFailed, err)
+
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v11"), val)
+}
+
+func TestDB_WriteBatch_Savepoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-savepoint")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	// 读到批次中暂存的数据
+	err = wb.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	val, err := wb.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	val, err = wb.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Equal(t, 1, wb.Len())
+	assert.Equal(t, int64(len(utils.GetTestKey(2))+2), wb.ByteSize())
+
+	// 回滚到保存点
+	wb.SetSavepoint()
+	err = wb.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Nil(t, err)
+	err = wb.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = wb.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 3, wb.Len())
+
+	err = wb.RollbackToSavepoint()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, wb.Len())
+	err = wb.RollbackToSavepoint()
+	assert.Equal(t, ErrNoSavepoint, err)
+
+	err = wb.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 整体回滚
+	wb2 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb2.Put(utils.GetTestKey(4), []byte("v4"))
+	assert.Nil(t, err)
+	wb2.Rollback()
+	assert.Equal(t, 0, wb2.Len())
+	err = wb2.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 超过最大字节数
+	wbOpts := DefaultWriteBatchOptions
+	wbOpts.MaxBatchBytes = 64
+	wb3 := db.NewWriteBatch(wbOpts)
+	err = wb3.Put(utils.GetTestKey(5), utils.RandomValue(64))
+	assert.Nil(t, err)
+	err = wb3.Commit()
+	assert.Equal(t, ErrExceedMaxBatchBytes, err)
+}
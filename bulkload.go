@@ -0,0 +1,235 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// 批量导入时数据文件和 Hint 文件的写缓冲大小
+const bulkLoadBufferSize = 1024 * 1024
+
+// BulkLoader 离线批量导入数据，直接将编码之后的日志记录写入新的数据目录
+// 不经过 DB 的锁、索引和持久化策略，key 必须按照严格递增的顺序写入，每个 key 只写入一次。
+// 写入时同时生成 Hint 文件和 merge 完成标识文件，Finish 之后使用 Open 打开数据目录时直接从 Hint 文件中加载索引，
+// 不需要读取数据文件。不支持 B+ 树索引，不能并发使用
+type BulkLoader struct {
+	options  Options
+	filelock *flock.Flock
+	start    time.Time // 写入记录的提交时间
+
+	dataFile *data.DataFile
+	dataBuf  []byte
+	hintFile *data.DataFile
+	hintBuf  []byte
+
+	lastKey   []byte
+	commitSeq uint64 // 每条记录使用递增的提交序列号，打开之后的写入在此基础上继续
+	finished  bool
+}
+
+// NewBulkLoader 创建批量导入的数据目录，options.DirPath 必须不存在，数据文件按照 options.DataFileSize 切分
+// 导入完成之前持有数据目录的文件锁，导入过程中不能打开数据库
+func NewBulkLoader(options Options) (*BulkLoader, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	if options.IndexType == BPTree {
+		return nil, ErrBulkLoadBPTree
+	}
+	if _, err := os.Stat(options.DirPath); err == nil {
+		return nil, ErrBulkLoadDirExists
+	}
+	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	bl := &BulkLoader{options: options, start: time.Now()}
+	if err := bl.open(); err != nil {
+		bl.close()
+		_ = os.RemoveAll(options.DirPath)
+		return nil, err
+	}
+	return bl, nil
+}
+
+func (bl *BulkLoader) open() error {
+	bl.filelock = flock.New(filepath.Join(bl.options.DirPath, fileLockName))
+	hold, err := bl.filelock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !hold {
+		return ErrDatabaseIsUsing
+	}
+
+	if bl.dataFile, err = data.OpenDataFile(bl.options.DirPath, 0, fio.StandardIO); err != nil {
+		return err
+	}
+	bl.hintFile, err = data.OpenHintFile(bl.options.DirPath)
+	return err
+}
+
+// Put 写入一条永不过期的数据
+func (bl *BulkLoader) Put(key, value []byte) error {
+	return bl.put(key, value, 0)
+}
+
+// PutWithTTL 写入一条数据，ttl 之后过期，ttl 为 0 表示永不过期
+func (bl *BulkLoader) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return bl.put(key, value, expire)
+}
+
+func (bl *BulkLoader) put(key, value []byte, expire int64) error {
+	if bl.finished {
+		return ErrBulkLoadFinished
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if bl.lastKey != nil && bytes.Compare(key, bl.lastKey) <= 0 {
+		return ErrBulkLoadKeyNotSorted
+	}
+
+	bl.commitSeq++
+	logRecord := &data.LogRecord{
+		Key:        logRecordKeyWithReq(key, nonTransactionSeqNo),
+		Value:      value,
+		Type:       data.LogRecordNormal,
+		Expire:     expire,
+		CommitSeq:  bl.commitSeq,
+		CommitTime: bl.start.UnixNano(),
+	}
+	encRecord, size := data.EncodeLogRecord(logRecord)
+
+	// 和 DB 写入时一样，达到数据文件的阈值之后切换到新的数据文件
+	writeOff := bl.dataFile.WriteOff + int64(len(bl.dataBuf))
+	if writeOff > 0 && writeOff+size > bl.options.DataFileSize {
+		if err := bl.rotate(); err != nil {
+			return err
+		}
+		writeOff = 0
+	}
+
+	bl.dataBuf = append(bl.dataBuf, encRecord...)
+	pos := &data.LogRecordPos{Fid: bl.dataFile.FileId, Offset: writeOff, Size: uint32(size), Expire: expire}
+	hintRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: key, Value: data.EncodeLogRecordPos(pos)})
+	bl.hintBuf = append(bl.hintBuf, hintRecord...)
+	bl.lastKey = append(bl.lastKey[:0], key...)
+
+	if len(bl.dataBuf) >= bulkLoadBufferSize {
+		if err := bl.flushData(); err != nil {
+			return err
+		}
+	}
+	if len(bl.hintBuf) >= bulkLoadBufferSize {
+		return bl.flushHint()
+	}
+	return nil
+}
+
+func (bl *BulkLoader) flushData() error {
+	if len(bl.dataBuf) == 0 {
+		return nil
+	}
+	if err := bl.dataFile.Write(bl.dataBuf); err != nil {
+		return err
+	}
+	bl.dataBuf = bl.dataBuf[:0]
+	return nil
+}
+
+func (bl *BulkLoader) flushHint() error {
+	if len(bl.hintBuf) == 0 {
+		return nil
+	}
+	if err := bl.hintFile.Write(bl.hintBuf); err != nil {
+		return err
+	}
+	bl.hintBuf = bl.hintBuf[:0]
+	return nil
+}
+
+// 持久化并关闭当前的数据文件，打开下一个数据文件
+func (bl *BulkLoader) rotate() error {
+	if err := bl.flushData(); err != nil {
+		return err
+	}
+	if err := bl.dataFile.Sync(); err != nil {
+		return err
+	}
+	fid := bl.dataFile.FileId + 1
+	if err := bl.dataFile.Close(); err != nil {
+		return err
+	}
+	dataFile, err := data.OpenDataFile(bl.options.DirPath, fid, fio.StandardIO)
+	if err != nil {
+		bl.dataFile = nil
+		return err
+	}
+	bl.dataFile = dataFile
+	return nil
+}
+
+// Finish 持久化所有的数据文件和 Hint 文件，并写入 merge 完成标识文件，之后可以使用 Open 打开数据目录
+// 最后再创建一个空的活跃文件，导入的数据文件都作为 merge 重写的文件，打开时不会被读取
+func (bl *BulkLoader) Finish() error {
+	if bl.finished {
+		return ErrBulkLoadFinished
+	}
+	bl.finished = true
+	defer bl.close()
+
+	if err := bl.rotate(); err != nil {
+		return err
+	}
+	if err := bl.flushHint(); err != nil {
+		return err
+	}
+	if err := bl.hintFile.Sync(); err != nil {
+		return err
+	}
+
+	// merge 完成标识文件最后写入，没有此文件时打开数据库会读取所有的数据文件重建索引
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(bl.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer mergeFinishedFile.Close()
+	// 同时保存最后一条记录的提交序列号，打开数据库时不需要读取数据文件恢复
+	if err := mergeFinishedFile.Write(encodeMergeFinished(bl.dataFile.FileId, bl.commitSeq)); err != nil {
+		return err
+	}
+	return mergeFinishedFile.Sync()
+}
+
+// Abort 放弃导入，删除已经写入的数据目录
+func (bl *BulkLoader) Abort() error {
+	if bl.finished {
+		return ErrBulkLoadFinished
+	}
+	bl.finished = true
+	bl.close()
+	return os.RemoveAll(bl.options.DirPath)
+}
+
+func (bl *BulkLoader) close() {
+	if bl.dataFile != nil {
+		_ = bl.dataFile.Close()
+	}
+	if bl.hintFile != nil {
+		_ = bl.hintFile.Close()
+	}
+	if bl.filelock != nil {
+		_ = bl.filelock.Unlock()
+	}
+}
@@ -0,0 +1,92 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkLoader(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "bitcask-go-bulkload")
+	defer os.RemoveAll(parent)
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(parent, "db")
+	opts.DataFileSize = 32 * 1024
+
+	bl, err := NewBulkLoader(opts)
+	assert.Nil(t, err)
+	// 导入完成之前不能打开数据库
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, bl.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, bl.PutWithTTL([]byte("zz-ttl"), []byte("ttl"), time.Hour))
+	assert.Equal(t, ErrBulkLoadKeyNotSorted, bl.Put(utils.GetTestKey(1), []byte("unsorted")))
+	assert.Equal(t, ErrBulkLoadKeyNotSorted, bl.Put([]byte("zz-ttl"), []byte("duplicated")))
+	assert.Nil(t, bl.Finish())
+	assert.Equal(t, ErrBulkLoadFinished, bl.Put([]byte("zzz"), []byte("1")))
+
+	files, err := listDataFiles(opts.DirPath)
+	assert.Nil(t, err)
+	assert.True(t, len(files) > 2)
+	report, err := Verify(opts.DirPath)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1001, report.HintRecords)
+
+	// 导入的数据文件不会被读取，损坏之后仍然可以打开，提交序列号从 merge 完成标识文件中恢复
+	lastFid := files[len(files)-1].fid
+	assert.Nil(t, os.Truncate(data.GetDataFileName(opts.DirPath, lastFid-1), 0))
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	assert.Equal(t, lastFid, db.activeFile.FileId)
+	assert.Equal(t, uint64(1001), db.Stat().CommitSeq)
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	ttl, err := db.TTL([]byte("zz-ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 50*time.Minute)
+
+	// 导入之后的写入在新的活跃文件中继续
+	assert.Nil(t, db.Put([]byte("new-key"), []byte("new-value")))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("new-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+	assert.Equal(t, 1002, len(db.ListKeys()))
+}
+
+func TestBulkLoader_Abort(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "bitcask-go-bulkload-abort")
+	defer os.RemoveAll(parent)
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(parent, "db")
+
+	bl, err := NewBulkLoader(opts)
+	assert.Nil(t, err)
+	_, err = NewBulkLoader(opts)
+	assert.Equal(t, ErrBulkLoadDirExists, err)
+
+	assert.Nil(t, bl.Put([]byte("a"), []byte("1")))
+	assert.Equal(t, ErrKeyIsEmpty, bl.Put(nil, []byte("1")))
+	assert.Nil(t, bl.Abort())
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	opts.IndexType = BPTree
+	_, err = NewBulkLoader(opts)
+	assert.Equal(t, ErrBulkLoadBPTree, err)
+}
@@ -0,0 +1,259 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/index"
+	"bitcask-kv/utils"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckpointManifestName 检查点目录中清单文件的名称，清单文件最后写入，存在即说明检查点已经完整创建
+const CheckpointManifestName = "checkpoint-manifest"
+
+// CheckpointManifest 检查点和备份的清单
+type CheckpointManifest struct {
+	SeqNo         uint64           `json:"seq_no"`           // 创建检查点时的事务序列号
+	CreatedAt     time.Time        `json:"created_at"`       // 创建检查点的时间
+	MergeBoundary uint32           `json:"merge_boundary"`   // 小于此 id 的数据文件是 merge 重写生成的
+	Parent        string           `json:"parent,omitempty"` // 增量备份基于的上一个备份的目录名称，为空表示全量备份
+	Files         []CheckpointFile `json:"files"`            // 检查点包含的文件，不包括清单文件本身
+}
+
+// CheckpointFile 检查点中的一个文件
+type CheckpointFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Linked bool   `json:"linked"` // 是否为数据目录中文件的硬链接，false 表示拷贝
+	// 增量备份中从之前的备份复用的文件，为文件所在的备份目录名称，为空表示文件在当前目录中
+	Backup string `json:"backup,omitempty"`
+}
+
+// Checkpoint 在 dir 目录中创建数据库当前状态的检查点，dir 必须不存在，可以直接作为数据目录打开
+// 先将活跃文件切换为旧的数据文件，旧的数据文件不会再被修改，因此只需要在持有锁时记录文件列表，
+// 之后释放锁再通过硬链接创建文件，不支持硬链接时（例如跨文件系统）拷贝文件。
+// 使用 B+ 树索引时需要在持有锁时拷贝索引文件。只读实例和复制的从节点不能创建检查点
+func (db *DB) Checkpoint(dir string) error {
+	return db.createCheckpoint(dir, nil, "")
+}
+
+// 创建检查点，prev 不为空时为增量备份，prev 中大小相同并且没有被 merge 重写的文件不再重复创建
+func (db *DB) createCheckpoint(dir string, prev *CheckpointManifest, prevName string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return ErrCheckpointDirExists
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := db.checkpoint(dir, prev, prevName); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) checkpoint(dir string, prev *CheckpointManifest, prevName string) error {
+	manifest := &CheckpointManifest{CreatedAt: time.Now(), Parent: prevName}
+
+	// B+ 树索引文件会被修改，需要在持有锁时拷贝
+	files, err := db.sealFiles(func() error {
+		bpt, ok := db.index.(*index.BPlusTree)
+		if !ok {
+			return nil
+		}
+		if err := bpt.CopyTo(dir); err != nil {
+			return err
+		}
+		info, err := os.Stat(filepath.Join(dir, index.BPTreeIndexFileName))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: index.BPTreeIndexFileName, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	manifest.SeqNo = files.seqNo
+	manifest.MergeBoundary = files.mergeBoundary
+
+	for _, name := range files.fileNames {
+		srcName := filepath.Join(db.options.DirPath, name)
+		info, err := os.Stat(srcName)
+		if err != nil {
+			return err
+		}
+		if backup, ok := prev.reusableFile(name, info.Size(), manifest.MergeBoundary, prevName); ok {
+			manifest.Files = append(manifest.Files, CheckpointFile{Name: name, Size: info.Size(), Backup: backup})
+			continue
+		}
+		linked, err := utils.LinkOrCopyFile(srcName, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: name, Size: info.Size(), Linked: linked})
+	}
+
+	// 创建空的活跃文件
+	if files.activeFileName != "" {
+		activeFile, err := os.OpenFile(filepath.Join(dir, files.activeFileName),
+			os.O_CREATE|os.O_EXCL|os.O_WRONLY, fio.DataFilePerm)
+		if err != nil {
+			return err
+		}
+		if err := activeFile.Close(); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CheckpointFile{Name: files.activeFileName})
+	}
+
+	if err := writeSeqNoFile(dir, manifest.SeqNo); err != nil {
+		return err
+	}
+	return writeCheckpointManifest(dir, manifest)
+}
+
+// 检查点和备份需要的数据目录中的文件
+type sealedFiles struct {
+	fileNames      []string // 旧的数据文件、Hint 文件和 merge 完成标识文件，在数据库打开期间都不会被修改
+	activeFileName string   // 新的空活跃文件的名称，为空表示还没有数据文件
+	seqNo          uint64
+	mergeBoundary  uint32
+}
+
+// 将活跃文件转换为旧的数据文件，并记录当前所有不会再被修改的文件，只需要短暂地持有锁
+// 检查点中使用一个新的空文件作为活跃文件，打开检查点之后的写入不会修改通过硬链接共享的数据文件。
+// onLocked 在持有锁时调用，用于拷贝会被修改的 B+ 树索引
+func (db *DB) sealFiles(onLocked func() error) (*sealedFiles, error) {
+	files := &sealedFiles{}
+
+	db.mtx.Lock()
+	// 切换活跃文件需要写入数据目录
+	if err := db.checkWritable(); err != nil {
+		db.mtx.Unlock()
+		return nil, err
+	}
+
+	if db.activeFile != nil && db.activeFile.WriteOff > 0 {
+		if err := db.activeFile.Sync(); err != nil {
+			db.mtx.Unlock()
+			return nil, err
+		}
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.setActiveDataFile(); err != nil {
+			db.mtx.Unlock()
+			return nil, err
+		}
+	}
+
+	var fileIds []uint32
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		files.activeFileName = filepath.Base(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	}
+	files.seqNo = db.seqNo
+	files.mergeBoundary = db.mergeBoundary
+
+	if err := onLocked(); err != nil {
+		db.mtx.Unlock()
+		return nil, err
+	}
+	db.mtx.Unlock()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	for _, fid := range fileIds {
+		files.fileNames = append(files.fileNames, filepath.Base(data.GetDataFileName(db.options.DirPath, fid)))
+	}
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		if _, err := os.Stat(filepath.Join(db.options.DirPath, name)); err == nil {
+			files.fileNames = append(files.fileNames, name)
+		}
+	}
+	return files, nil
+}
+
+// 查找上一个备份中可以复用的文件，返回文件内容所在的备份目录名称
+// 数据文件在 merge 之后会被重写为相同 id 的文件，只有大小相同并且没有被新的 merge 重写的文件才能复用
+func (m *CheckpointManifest) reusableFile(name string, size int64, mergeBoundary uint32, prevName string) (string, bool) {
+	if m == nil || size == 0 {
+		return "", false
+	}
+	if m.MergeBoundary != mergeBoundary {
+		fid, ok := parseDataFileName(name)
+		if !ok || fid < mergeBoundary {
+			return "", false
+		}
+	}
+	for _, file := range m.Files {
+		if file.Name != name || file.Size != size {
+			continue
+		}
+		if file.Backup != "" {
+			return file.Backup, true
+		}
+		return prevName, true
+	}
+	return "", false
+}
+
+// 解析数据文件名称中的文件 id
+func parseDataFileName(name string) (uint32, bool) {
+	if !strings.HasSuffix(name, data.DataFileNameSuffix) {
+		return 0, false
+	}
+	fid, err := strconv.Atoi(strings.TrimSuffix(name, data.DataFileNameSuffix))
+	if err != nil {
+		return 0, false
+	}
+	return uint32(fid), true
+}
+
+// 写入清单文件，先写临时文件再重命名，保证清单文件是完整的
+func writeCheckpointManifest(dir string, manifest *CheckpointManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpName := filepath.Join(dir, CheckpointManifestName+".tmp")
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, CheckpointManifestName))
+}
+
+// ReadCheckpointManifest 读取检查点目录中的清单
+func ReadCheckpointManifest(dir string) (*CheckpointManifest, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, CheckpointManifestName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &CheckpointManifest{}
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,71 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+
+	cpDir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-dest")
+	cpDir = filepath.Join(cpDir, "cp")
+	defer os.RemoveAll(filepath.Dir(cpDir))
+	assert.Nil(t, db.Checkpoint(cpDir))
+	assert.Equal(t, ErrCheckpointDirExists, db.Checkpoint(cpDir))
+
+	manifest, err := ReadCheckpointManifest(cpDir)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), manifest.SeqNo)
+	assert.True(t, len(manifest.Files) > 1)
+	for _, file := range manifest.Files {
+		if file.Size > 0 {
+			assert.True(t, file.Linked)
+		}
+	}
+
+	// 创建检查点之后的写入不会出现在检查点中
+	assert.Nil(t, db.Put(utils.GetTestKey(2000), []byte("after")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	cpOpts := opts
+	cpOpts.DirPath = cpDir
+	cpDB, err := Open(cpOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, len(cpDB.ListKeys()))
+	_, err = cpDB.Get(utils.GetTestKey(2000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = cpDB.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+
+	// 检查点中的写入不会修改原数据库共享的数据文件
+	assert.Nil(t, cpDB.Put(utils.GetTestKey(3000), []byte("checkpoint")))
+	assert.Nil(t, cpDB.Close())
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(3000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+}
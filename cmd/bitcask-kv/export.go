@@ -0,0 +1,151 @@
+package main
+
+import (
+	bitcask "bitcask-kv"
+	bitcask_datatype "bitcask-kv/datatype"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 导出和导入的数据格式名称
+var exportFormats = map[string]bitcask.ExportFormat{
+	"jsonl": bitcask.ExportJSONLines,
+	"csv":   bitcask.ExportCSV,
+}
+
+// 以只读方式打开数据库，将数据导出到文件或者标准输出
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "output format, jsonl or csv")
+	prefix := fs.String("prefix", "", "only export keys with this prefix")
+	useBase64 := fs.Bool("base64", false, "encode all keys and values in base64")
+	datatype := fs.Bool("datatype", false, "export hash, set, list and zset keys as a whole, only supports jsonl")
+	output := fs.String("o", "", "output file, default is the standard output")
+	progress := fs.Bool("progress", false, "report progress to the standard error")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bitcask-kv export [-format jsonl|csv] [-prefix p] [-base64] [-datatype] [-o file] <dir>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	exportFormat, ok := exportFormats[*format]
+	if fs.NArg() != 1 || !ok || (*datatype && exportFormat != bitcask.ExportJSONLines) {
+		fs.Usage()
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = fs.Arg(0)
+	opts.ReadOnly = true
+	exportOpts := bitcask.DefaultExportOptions
+	exportOpts.Prefix = []byte(*prefix)
+	exportOpts.Base64 = *useBase64
+	if *progress {
+		exportOpts.Progress = func(count int) {
+			fmt.Fprintf(os.Stderr, "exported %d\n", count)
+		}
+	}
+
+	var count int
+	var err error
+	if *datatype {
+		var dts *bitcask_datatype.DataTypeService
+		if dts, err = bitcask_datatype.NewDataTypeService(opts); err == nil {
+			count, err = dts.Export(w, exportOpts)
+			_ = dts.Close()
+		}
+	} else {
+		var db *bitcask.DB
+		if db, err = bitcask.Open(opts); err == nil {
+			count, err = db.Export(w, exportFormat, exportOpts)
+			_ = db.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d keys exported\n", count)
+	return 0
+}
+
+// 将文件或者标准输入中的数据导入到数据库中
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "input format, jsonl or csv")
+	batchNum := fs.Uint("batch", bitcask.DefaultImportOptions.BatchNum, "the maximum number of writes in a write batch")
+	syncWrites := fs.Bool("sync", false, "sync the data file after each write batch")
+	datatype := fs.Bool("datatype", false, "import data exported with -datatype, only supports jsonl")
+	input := fs.String("i", "", "input file, default is the standard input")
+	progress := fs.Bool("progress", false, "report progress to the standard error")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bitcask-kv import [-format jsonl|csv] [-batch n] [-sync] [-datatype] [-i file] <dir>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	exportFormat, ok := exportFormats[*format]
+	if fs.NArg() != 1 || !ok || (*datatype && exportFormat != bitcask.ExportJSONLines) {
+		fs.Usage()
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = fs.Arg(0)
+	importOpts := bitcask.DefaultImportOptions
+	importOpts.BatchNum = *batchNum
+	importOpts.SyncWrites = *syncWrites
+	if *progress {
+		importOpts.Progress = func(count int) {
+			fmt.Fprintf(os.Stderr, "imported %d\n", count)
+		}
+	}
+
+	var count int
+	var err error
+	if *datatype {
+		var dts *bitcask_datatype.DataTypeService
+		if dts, err = bitcask_datatype.NewDataTypeService(opts); err == nil {
+			count, err = dts.Import(r, importOpts)
+			if closeErr := dts.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	} else {
+		var db *bitcask.DB
+		if db, err = bitcask.Open(opts); err == nil {
+			count, err = db.Import(r, exportFormat, importOpts)
+			if closeErr := db.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d keys imported\n", count)
+	return 0
+}
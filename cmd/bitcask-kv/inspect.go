@@ -0,0 +1,141 @@
+package main
+
+import (
+	bitcask "bitcask-kv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON lines 格式输出的一条记录
+type inspectRecordJSON struct {
+	File       string  `json:"file"`
+	Offset     int64   `json:"offset"`
+	Size       int64   `json:"size"`
+	Type       string  `json:"type,omitempty"`
+	SeqNo      uint64  `json:"seq_no,omitempty"`
+	Key        string  `json:"key,omitempty"`
+	Value      string  `json:"value,omitempty"`
+	Expire     int64   `json:"expire,omitempty"`
+	CommitSeq  uint64  `json:"commit_seq,omitempty"`
+	CommitTime int64   `json:"commit_time,omitempty"`
+	Fid        *uint32 `json:"fid,omitempty"`
+	PosOffset  *int64  `json:"pos_offset,omitempty"`
+	Live       bool    `json:"live"`
+	Corrupted  string  `json:"corrupted,omitempty"`
+}
+
+// 解析数据目录中的文件并输出每条记录和每个文件的统计信息
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := fs.String("format", "text", "output format, text or json")
+	maxLen := fs.Int("max-len", 64, "truncate keys and values longer than this many bytes, 0 means no limit")
+	summaryOnly := fs.Bool("summary", false, "only print the summary of each file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bitcask-kv inspect [-format text|json] [-max-len n] [-summary] <dir> [file...]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	summaries, err := bitcask.Inspect(fs.Arg(0), fs.Args()[1:], func(record *bitcask.InspectRecord) error {
+		if *summaryOnly {
+			return nil
+		}
+		if *format == "json" {
+			return encoder.Encode(newInspectRecordJSON(record, *maxLen))
+		}
+		_, err := fmt.Println(formatInspectRecord(record, *maxLen))
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+		return 1
+	}
+
+	for _, summary := range summaries {
+		if *format == "json" {
+			if err := encoder.Encode(map[string]*bitcask.InspectSummary{"summary": summary}); err != nil {
+				fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+				return 1
+			}
+			continue
+		}
+		fmt.Printf("%s: records=%d live=%d live_bytes=%d dead_bytes=%d corrupted_bytes=%d\n",
+			summary.File, summary.Records, summary.LiveRecords, summary.LiveBytes, summary.DeadBytes, summary.CorruptedBytes)
+	}
+	return 0
+}
+
+func formatInspectRecord(record *bitcask.InspectRecord, maxLen int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s@%d size=%d", record.File, record.Offset, record.Size)
+	if record.Corrupted != "" {
+		fmt.Fprintf(&sb, " corrupted=%q", record.Corrupted)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, " type=%s", record.Type)
+	if record.SeqNo > 0 {
+		fmt.Fprintf(&sb, " seq=%d", record.SeqNo)
+	}
+	if record.CommitSeq > 0 {
+		fmt.Fprintf(&sb, " commit_seq=%d commit_time=%s", record.CommitSeq,
+			time.Unix(0, record.CommitTime).Format(time.RFC3339Nano))
+	}
+	if record.Expire > 0 {
+		fmt.Fprintf(&sb, " expire=%s", time.Unix(0, record.Expire).Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&sb, " key=\"%s\"", escape(record.Key, maxLen))
+	if record.Pos != nil {
+		fmt.Fprintf(&sb, " pos=%d@%d size=%d", record.Pos.Fid, record.Pos.Offset, record.Pos.Size)
+	} else {
+		fmt.Fprintf(&sb, " value=\"%s\"", escape(record.Value, maxLen))
+	}
+	if record.Live {
+		sb.WriteString(" live")
+	} else {
+		sb.WriteString(" dead")
+	}
+	return sb.String()
+}
+
+func newInspectRecordJSON(record *bitcask.InspectRecord, maxLen int) *inspectRecordJSON {
+	r := &inspectRecordJSON{
+		File:       record.File,
+		Offset:     record.Offset,
+		Size:       record.Size,
+		Type:       record.Type,
+		SeqNo:      record.SeqNo,
+		Key:        escape(record.Key, maxLen),
+		Expire:     record.Expire,
+		CommitSeq:  record.CommitSeq,
+		CommitTime: record.CommitTime,
+		Live:       record.Live,
+		Corrupted:  record.Corrupted,
+	}
+	if record.Pos != nil {
+		r.Fid, r.PosOffset = &record.Pos.Fid, &record.Pos.Offset
+	} else {
+		r.Value = escape(record.Value, maxLen)
+	}
+	return r
+}
+
+// 转义不可打印的字符，超过 maxLen 字节的部分截断
+func escape(b []byte, maxLen int) string {
+	var suffix string
+	if maxLen > 0 && len(b) > maxLen {
+		suffix = fmt.Sprintf("...(%d bytes)", len(b))
+		b = b[:maxLen]
+	}
+	quoted := strconv.Quote(string(b))
+	return quoted[1:len(quoted)-1] + suffix
+}
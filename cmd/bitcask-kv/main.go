@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 子命令的处理函数，返回进程的退出码
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+// 子命令名称和对应处理函数的映射
+var commands = map[string]command{
+	"verify":  {usage: "verify <dir>", run: runVerify},
+	"inspect": {usage: "inspect [-format text|json] [-max-len n] [-summary] <dir> [file...]", run: runInspect},
+	"export":  {usage: "export [-format jsonl|csv] [-prefix p] [-base64] [-datatype] [-o file] <dir>", run: runExport},
+	"import":  {usage: "import [-format jsonl|csv] [-batch n] [-sync] [-datatype] [-i file] <dir>", run: runImport},
+	"repair":  {usage: "repair -out <dir> <dir>", run: runRepair},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: bitcask-kv <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
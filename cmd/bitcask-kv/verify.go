@@ -0,0 +1,76 @@
+package main
+
+import (
+	bitcask "bitcask-kv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 校验数据目录，发现问题时返回 1
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bitcask-kv "+"verify <dir>")
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	report, err := bitcask.Verify(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 1
+	}
+	printVerifyReport(os.Stdout, report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// 修复数据目录，将有效的记录写入到新的目录中
+func runRepair(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	outDir := fs.String("out", "", "the directory to write the repaired data files, must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bitcask-kv "+"repair -out <dir> <dir>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *outDir == "" {
+		fs.Usage()
+		return 2
+	}
+
+	report, err := bitcask.Repair(fs.Arg(0), *outDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repair: %v\n", err)
+		return 1
+	}
+	printVerifyReport(os.Stdout, report)
+	fmt.Printf("repaired data directory written to %s\n", *outDir)
+	return 0
+}
+
+func printVerifyReport(w io.Writer, report *bitcask.VerifyReport) {
+	for _, issue := range report.Issues {
+		fmt.Fprintln(w, issue)
+	}
+	fmt.Fprintf(w, "data files: %d, records: %d, hint records: %d\n",
+		report.DataFiles, report.Records, report.HintRecords)
+	if report.IncompleteTxnRecords > 0 {
+		fmt.Fprintf(w, "records of uncommitted transactions: %d\n", report.IncompleteTxnRecords)
+	}
+	if report.PendingMerge {
+		fmt.Fprintln(w, "a finished merge will be applied at the next open")
+	}
+	if report.OK() {
+		fmt.Fprintln(w, "OK")
+	} else {
+		fmt.Fprintf(w, "%d issues found\n", len(report.Issues))
+	}
+}
@@ -0,0 +1,150 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 保存跳过的损坏数据的目录名称
+const quarantineDirName = "quarantine"
+
+// 查找下一条有效记录时每次读取的字节数
+var recoveryScanChunkSize int64 = 4 * 1024 * 1024
+
+// RecoveryReport 打开数据库时从数据文件加载索引的恢复结果
+type RecoveryReport struct {
+	TruncatedBytes   int64             // 活跃文件末尾没有写完整而被截断的字节数
+	CorruptedBytes   int64             // 跳过的损坏数据的字节数
+	CorruptedRegions []CorruptedRegion // 跳过的损坏数据
+	SkippedRecords   int               // 没有事务完成记录而被丢弃的事务中的记录数量
+}
+
+// CorruptedRegion 数据文件中一段损坏的数据
+type CorruptedRegion struct {
+	Fid            uint32
+	Offset         int64
+	Size           int64
+	QuarantineFile string // 保存损坏数据的文件，为空表示没有保存
+}
+
+// DroppedBytes 恢复时丢弃的总字节数
+func (r *RecoveryReport) DroppedBytes() int64 {
+	return r.TruncatedBytes + r.CorruptedBytes
+}
+
+// RecoveryReport 获取打开数据库时的恢复结果
+func (db *DB) RecoveryReport() RecoveryReport {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	report := db.recoveryReport
+	report.CorruptedRegions = append([]CorruptedRegion(nil), db.recoveryReport.CorruptedRegions...)
+	return report
+}
+
+// 按照恢复策略处理数据文件 offset 处无法解析的数据，返回继续读取的位置，-1 表示当前文件不需要继续读取
+// 之后没有有效的记录并且是最后一个数据文件时，说明是写入过程中崩溃留下的不完整记录，截断文件；
+// 否则是数据损坏，跳过损坏的数据，从下一条有效的记录继续读取
+// 只读实例不会修改数据目录，末尾不完整的记录可能是写入进程正在追加的数据，直接停止读取
+func (db *DB) recoverDataFile(dataFile *data.DataFile, offset int64, isLast bool) (int64, error) {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	next, err := findNextLogRecord(dataFile, offset+1, fileSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if next < 0 && isLast {
+		if db.options.ReadOnly {
+			return -1, nil
+		}
+		if db.options.RecoveryPolicy == RecoveryStrict {
+			return 0, fmt.Errorf("%w: torn write at the end of file %d, offset %d", ErrDataFileCorrupted, dataFile.FileId, offset)
+		}
+		if err := os.Truncate(data.GetDataFileName(db.options.DirPath, dataFile.FileId), offset); err != nil {
+			return 0, err
+		}
+		db.recoveryReport.TruncatedBytes += fileSize - offset
+		return -1, nil
+	}
+
+	if next < 0 {
+		next = fileSize
+	}
+	if db.options.RecoveryPolicy != RecoverySkipCorrupted && db.options.RecoveryPolicy != RecoveryQuarantine {
+		return 0, fmt.Errorf("%w: file %d, offset %d, %d bytes", ErrDataFileCorrupted, dataFile.FileId, offset, next-offset)
+	}
+
+	region := CorruptedRegion{Fid: dataFile.FileId, Offset: offset, Size: next - offset}
+	if db.options.RecoveryPolicy == RecoveryQuarantine && !db.options.ReadOnly {
+		fileName, err := db.quarantine(dataFile, offset, next)
+		if err != nil {
+			return 0, err
+		}
+		region.QuarantineFile = fileName
+	}
+	db.recoveryReport.CorruptedBytes += region.Size
+	db.recoveryReport.CorruptedRegions = append(db.recoveryReport.CorruptedRegions, region)
+	return next, nil
+}
+
+// 从 start 开始查找下一条可以完整解析并且 CRC 校验通过的记录，没有找到时返回 -1
+// 每次读取一段数据到窗口中逐字节查找，避免对每个候选位置单独读取文件
+func findNextLogRecord(dataFile *data.DataFile, start, fileSize int64) (int64, error) {
+	var buf []byte
+	bufStart := start
+	for offset := start; offset < fileSize; offset++ {
+		i := offset - bufStart
+		bufEnd := bufStart + int64(len(buf))
+		// 头部超出了窗口，或者记录完整地位于文件中但是超出了窗口时，从当前位置重新读取
+		// 超出文件范围的记录不可能是完整的记录，不需要读取
+		size, ok := data.DecodeLogRecordSize(buf[i:])
+		if (!ok && bufEnd < fileSize) || (ok && offset+size <= fileSize && offset+size > bufEnd) {
+			n := min(max(recoveryScanChunkSize, size), fileSize-offset)
+			var err error
+			if buf, err = dataFile.ReadBytes(n, offset); err != nil && err != io.EOF {
+				return 0, err
+			}
+			bufStart, i = offset, 0
+		}
+		logRecord, _, err := data.DecodeLogRecord(buf[i:])
+		if err == nil && logRecord.Type <= data.LogRecordRangeDeleted {
+			return offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// 将损坏的数据保存到 quarantine 目录中，返回保存的文件路径
+func (db *DB) quarantine(dataFile *data.DataFile, start, end int64) (string, error) {
+	dir := filepath.Join(db.options.DirPath, quarantineDirName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	fileName := filepath.Join(dir, fmt.Sprintf("%09d-%d.corrupt", dataFile.FileId, start))
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	for offset := start; offset < end; offset += recoveryScanChunkSize {
+		buf, err := dataFile.ReadBytes(min(recoveryScanChunkSize, end-offset), offset)
+		if err != nil && err != io.EOF {
+			_ = file.Close()
+			return "", err
+		}
+		if _, err := file.Write(buf); err != nil {
+			_ = file.Close()
+			return "", err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	return fileName, file.Close()
+}
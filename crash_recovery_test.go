@@ -0,0 +1,143 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 在数据文件的末尾追加数据，模拟写入过程中崩溃
+func appendToDataFile(t *testing.T, dirPath string, fid uint32, buf []byte) {
+	file, err := os.OpenFile(data.GetDataFileName(dirPath, fid), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestDB_RecoverTornTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-torn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 最后一条记录只写入了一部分，以及没有提交的事务
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithReq([]byte("uncommitted"), 99),
+		Value: []byte("value"),
+	})
+	appendToDataFile(t, dir, 0, encRecord)
+	encRecord, _ = data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn"), Value: utils.RandomValue(64)})
+	appendToDataFile(t, dir, 0, encRecord[:20])
+
+	// 严格模式下打开失败
+	strictOpts := opts
+	strictOpts.RecoveryPolicy = RecoveryStrict
+	_, err = Open(strictOpts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, int64(20), report.TruncatedBytes)
+	assert.Equal(t, 1, report.SkippedRecords)
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	// 截断之后可以继续写入
+	assert.Nil(t, db.Put(utils.GetTestKey(100), []byte("after recovery")))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.RecoveryReport().TruncatedBytes)
+	val, err := db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after recovery"), val)
+}
+
+func TestDB_RecoverCorruptedRegion(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 旧数据文件中间的数据损坏
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted data"), 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	opts.RecoveryPolicy = RecoveryQuarantine
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, 1, len(report.CorruptedRegions))
+	region := report.CorruptedRegions[0]
+	assert.Equal(t, uint32(0), region.Fid)
+	assert.True(t, region.Offset <= 1000 && region.Offset+region.Size > 1000)
+	assert.Equal(t, region.Size, report.DroppedBytes())
+
+	// 损坏的数据保存到了 quarantine 目录中
+	assert.Equal(t, filepath.Join(dir, quarantineDirName), filepath.Dir(region.QuarantineFile))
+	info, err := os.Stat(region.QuarantineFile)
+	assert.Nil(t, err)
+	assert.Equal(t, region.Size, info.Size())
+
+	// 只丢失了损坏区域中的记录
+	keys := len(db.ListKeys())
+	assert.True(t, keys < 500 && keys >= 495)
+	val, err := db.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestFindNextLogRecord(t *testing.T) {
+	chunkSize := recoveryScanChunkSize
+	recoveryScanChunkSize = 16
+	defer func() { recoveryScanChunkSize = chunkSize }()
+
+	dir, _ := os.MkdirTemp("", "bitcask-go-find-next-record")
+	defer os.RemoveAll(dir)
+	dataFile, err := data.OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// 损坏的数据中有头部声明的长度超出了文件范围的记录，有效的记录跨越了多个读取窗口
+	garbage := []byte{0, 0, 0, 0, 0, 0xfe, 0xff, 0xff, 0xff, 0x0f, 0, 1, 2, 3, 4, 5, 6}
+	enc, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("key"), Value: utils.RandomValue(64)})
+	assert.Nil(t, dataFile.Write(garbage))
+	assert.Nil(t, dataFile.Write(enc))
+	fileSize := dataFile.WriteOff
+
+	offset, err := findNextLogRecord(dataFile, 0, fileSize)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(garbage)), offset)
+
+	offset, err = findNextLogRecord(dataFile, int64(len(garbage))+1, fileSize)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), offset)
+}
@@ -55,6 +55,11 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+// OpenDataFileWithName 打开指定路径的数据文件，并使用 fileId 作为文件 id，用于打开不在数据目录中的归档文件
+func OpenDataFileWithName(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	return newDataFile(fileName, fileId, ioType)
+}
+
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	//初始化 IOManager 管理器接口
 	ioManager, err := fio.NewIOManager(fileName, ioType)
@@ -105,7 +110,16 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	// 计算日志记录的总长度
 	recordSize := headerSize + keySize + valueSize
-	logRecord := &LogRecord{Type: header.recordType}
+	// 记录还没有写完整，或者头部已经损坏，避免按照错误的长度分配内存
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
+	logRecord := &LogRecord{
+		Type:       header.recordType,
+		Expire:     header.expire,
+		CommitSeq:  header.commitSeq,
+		CommitTime: header.commitTime,
+	}
 	// 开始读取用户实际存储的 key/value 数据
 	if keySize > 0 || valueSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
@@ -166,6 +180,11 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	return nil
 }
 
+// ReadBytes 从指定偏移量开始读取 n 个字节
+func (df *DataFile) ReadBytes(n int64, offset int64) ([]byte, error) {
+	return df.readNBytes(n, offset)
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
 	_, err = df.IoManager.Read(b, offset)
@@ -1,10 +1,11 @@
 package data
 
 import (
-	"os"
-	"testing"
 	"bitcask-kv/fio"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
 )
 
 func TestOpenDataFile(t *testing.T) {
@@ -121,4 +122,40 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 	t.Log(string(readRec3.Key))
-}
\ No newline at end of file
+
+}
+
+// 读取带有过期时间的日志记录
+func TestDataFile_ReadLogRecord_Expire(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-datafile-expire")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+	defer dataFile.Close()
+
+	rec1 := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(res1)
+	assert.Nil(t, err)
+
+	rec2 := &LogRecord{
+		Key:    []byte("ttl"),
+		Value:  []byte("bitcask kv go"),
+		Expire: 1700000000000000000,
+	}
+	res2, size2 := EncodeLogRecord(rec2)
+	err = dataFile.Write(res2)
+	assert.Nil(t, err)
+
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec2)
+	assert.Equal(t, size2, readSize2)
+
+	_, _, err = dataFile.ReadLogRecord(size1 + size2)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,312 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLogFileRemoved = errors.New("the data file of the log reader has been removed by merge")
+)
+
+// DefaultLogReaderPollInterval LogReader 读取到日志末尾之后检查新写入的默认时间间隔
+const DefaultLogReaderPollInterval = 100 * time.Millisecond
+
+// LogCursor LogReader 读取的位置，持久化之后可以使用 NewLogReaderFromCursor 继续读取
+type LogCursor struct {
+	Fid    uint32 // 下一条待读取记录所在的文件 id
+	Offset int64  // 下一条待读取记录在文件中的偏移
+	// MergeBoundary 游标所在的 merge 版本，为最近一次 merge 未参与 merge 的最小文件 id，0 表示没有发生过 merge
+	// merge 使用相同的 id 重写小于新边界的文件，之后游标在这些文件中的偏移不再有效
+	MergeBoundary uint32
+}
+
+// LogReader 从指定的位置开始顺序读取数据目录中的日志记录，可以在其他进程中使用
+// 读取到活跃文件的末尾之后会等待新的写入，当前文件写满之后自动切换到下一个数据文件。
+// 当前读取的文件被 merge 替换或删除时返回 ErrLogFileRemoved，此时需要重新全量同步。
+// 读取到已经写入完整但是校验失败的记录时返回 ErrInvalidCRC，调用方需要跳过损坏的数据或者重新同步。
+// 读取到的日志记录的 key 中带有事务序列号，事务中的记录在读取到对应的事务完成记录之后才算有效
+type LogReader struct {
+	dirPath       string
+	fid           uint32 // 下一条待读取记录所在的文件 id
+	offset        int64  // 下一条待读取记录在文件中的偏移
+	mergeBoundary uint32 // 游标所在的 merge 版本
+	hasBoundary   bool   // 是否已经确定了游标所在的 merge 版本
+	file          *os.File
+	fileInfo      os.FileInfo
+	pollInterval  time.Duration
+}
+
+// NewLogReader 初始化一个从 (fid, offset) 开始读取的 LogReader，位置基于数据目录当前的 merge 版本
+func NewLogReader(dirPath string, fid uint32, offset int64) *LogReader {
+	return &LogReader{
+		dirPath:      dirPath,
+		fid:          fid,
+		offset:       offset,
+		pollInterval: DefaultLogReaderPollInterval,
+	}
+}
+
+// NewLogReaderFromCursor 从 Position 返回的游标继续读取，游标之后的 merge 重写了游标所在的文件时返回 ErrLogFileRemoved
+func NewLogReaderFromCursor(dirPath string, cursor LogCursor) *LogReader {
+	lr := NewLogReader(dirPath, cursor.Fid, cursor.Offset)
+	lr.mergeBoundary, lr.hasBoundary = cursor.MergeBoundary, true
+	return lr
+}
+
+// SetPollInterval 设置读取到日志末尾之后检查新写入的时间间隔
+func (lr *LogReader) SetPollInterval(interval time.Duration) {
+	lr.pollInterval = interval
+}
+
+// Position 下一条待读取记录的位置，可以持久化之后用于 NewLogReaderFromCursor 继续读取
+func (lr *LogReader) Position() (LogCursor, error) {
+	if !lr.hasBoundary {
+		if err := lr.checkMerge(); err != nil {
+			return LogCursor{}, err
+		}
+	}
+	return LogCursor{Fid: lr.fid, Offset: lr.offset, MergeBoundary: lr.mergeBoundary}, nil
+}
+
+// Next 读取下一条日志记录及其位置，没有新的写入时阻塞等待，直到 ctx 取消
+func (lr *LogReader) Next(ctx context.Context) (*LogRecord, *LogRecordPos, error) {
+	for {
+		logRecord, size, err := lr.tryNext()
+		if err == nil {
+			pos := &LogRecordPos{Fid: lr.fid, Offset: lr.offset, Size: uint32(size), Expire: logRecord.Expire}
+			lr.offset += size
+			return logRecord, pos, nil
+		}
+		if err != io.EOF {
+			return nil, nil, err
+		}
+
+		// 暂时没有新的数据，等待之后重试
+		timer := time.NewTimer(lr.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 尝试读取下一条日志记录，当前没有可读的数据时返回 io.EOF
+func (lr *LogReader) tryNext() (*LogRecord, int64, error) {
+	for {
+		if lr.file == nil {
+			opened, err := lr.openFile()
+			if err != nil || !opened {
+				return nil, 0, err
+			}
+		}
+
+		logRecord, size, err := lr.readLogRecord()
+		if err == nil {
+			return logRecord, size, nil
+		}
+		// 文件末尾的记录可能还没有写完整，和读取到末尾一样处理
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, 0, err
+		}
+
+		// 文件已经被 merge 删除或替换
+		if err := lr.checkRemoved(); err != nil {
+			return nil, 0, err
+		}
+
+		// 已经存在更新的数据文件，说明当前文件不会再有写入，再读取一次确认之后切换
+		nextFid, ok, err := lr.nextFileId()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			return nil, 0, io.EOF
+		}
+		logRecord, size, err = lr.readLogRecord()
+		if err == nil {
+			return logRecord, size, nil
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, 0, err
+		}
+		// 当前文件已经不会再有写入，末尾还有无法解析的数据说明文件已经损坏，不能直接跳过
+		fileInfo, err := lr.file.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		if lr.offset < fileInfo.Size() {
+			return nil, 0, ErrInvalidCRC
+		}
+		if err := lr.file.Close(); err != nil {
+			return nil, 0, err
+		}
+		lr.file, lr.fileInfo = nil, nil
+		lr.fid, lr.offset = nextFid, 0
+	}
+}
+
+// 打开当前位置对应的数据文件，文件还不存在时返回 false
+func (lr *LogReader) openFile() (bool, error) {
+	// 同名的文件可能已经被 merge 重写，先检查游标是否还有效
+	if err := lr.checkMerge(); err != nil {
+		return false, err
+	}
+
+	file, err := os.Open(GetDataFileName(lr.dirPath, lr.fid))
+	if err == nil {
+		fileInfo, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return false, err
+		}
+		lr.file, lr.fileInfo = file, fileInfo
+		return true, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// 文件已经被 merge 删除
+	if lr.fid < lr.mergeBoundary {
+		return false, ErrLogFileRemoved
+	}
+
+	// 文件 id 不连续，跳到下一个存在的数据文件
+	nextFid, ok, err := lr.nextFileId()
+	if err != nil || !ok {
+		return false, err
+	}
+	lr.fid, lr.offset = nextFid, 0
+	return lr.openFile()
+}
+
+// 从打开的文件中读取当前位置的日志记录
+func (lr *LogReader) readLogRecord() (*LogRecord, int64, error) {
+	fileInfo, err := lr.file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	fileSize := fileInfo.Size()
+	if lr.offset >= fileSize {
+		return nil, 0, io.EOF
+	}
+
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if lr.offset+headerBytes > fileSize {
+		headerBytes = fileSize - lr.offset
+	}
+	headerBuf := make([]byte, headerBytes)
+	if _, err := lr.file.ReadAt(headerBuf, lr.offset); err != nil {
+		return nil, 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return nil, 0, io.EOF
+	}
+
+	recordSize := headerSize + int64(header.keySize) + int64(header.valueSize)
+	if lr.offset+recordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, recordSize)
+	if _, err := lr.file.ReadAt(buf, lr.offset); err != nil {
+		return nil, 0, err
+	}
+	logRecord, size, err := DecodeLogRecord(buf)
+	// 恰好结束在文件末尾的记录可能还没有写完整，由调用方确认文件是否还会有写入
+	// 之后还有数据的记录已经写入完整，校验失败说明数据已经损坏
+	if err == ErrInvalidCRC && lr.offset+recordSize == fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return logRecord, size, err
+}
+
+// 检查当前打开的文件是否已经被删除或者被 merge 生成的同名文件替换
+func (lr *LogReader) checkRemoved() error {
+	fileInfo, err := os.Stat(GetDataFileName(lr.dirPath, lr.fid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrLogFileRemoved
+		}
+		return err
+	}
+	if !os.SameFile(fileInfo, lr.fileInfo) {
+		return ErrLogFileRemoved
+	}
+	return nil
+}
+
+// 检查游标之后是否发生过 merge，id 小于新边界的文件被重写之后游标的偏移不再有效
+func (lr *LogReader) checkMerge() error {
+	boundary, _, err := lr.nonMergeFileId()
+	if err != nil {
+		return err
+	}
+	if lr.hasBoundary && boundary != lr.mergeBoundary && lr.fid < boundary {
+		return ErrLogFileRemoved
+	}
+	lr.mergeBoundary, lr.hasBoundary = boundary, true
+	return nil
+}
+
+// 查找比当前文件 id 更大的下一个数据文件
+func (lr *LogReader) nextFileId() (uint32, bool, error) {
+	dirEntries, err := os.ReadDir(lr.dirPath)
+	if err != nil {
+		return 0, false, err
+	}
+	var nextFid uint32
+	var found bool
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), DataFileNameSuffix))
+		if err != nil {
+			continue
+		}
+		if uint32(fid) > lr.fid && (!found || uint32(fid) < nextFid) {
+			nextFid, found = uint32(fid), true
+		}
+	}
+	return nextFid, found, nil
+}
+
+// 读取最近一次 merge 之后未参与 merge 的最小文件 id
+func (lr *LogReader) nonMergeFileId() (uint32, bool, error) {
+	buf, err := os.ReadFile(filepath.Join(lr.dirPath, MergeFinishedFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	logRecord, _, err := DecodeLogRecord(buf)
+	if err != nil {
+		return 0, false, err
+	}
+	fid, err := strconv.Atoi(string(logRecord.Value))
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(fid), true, nil
+}
+
+// Close 关闭 LogReader 打开的文件
+func (lr *LogReader) Close() error {
+	if lr.file == nil {
+		return nil
+	}
+	err := lr.file.Close()
+	lr.file, lr.fileInfo = nil, nil
+	return err
+}
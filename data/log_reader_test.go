@@ -0,0 +1,216 @@
+package data
+
+import (
+	"bitcask-kv/fio"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogReader_Next(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-log-reader")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	enc1, size1 := EncodeLogRecord(&LogRecord{Key: []byte("key-1"), Value: []byte("value-1")})
+	assert.Nil(t, dataFile.Write(enc1))
+
+	reader := NewLogReader(dir, 0, 0)
+	reader.SetPollInterval(time.Millisecond * 10)
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rec, pos, err := reader.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-1"), rec.Key)
+	assert.Equal(t, &LogRecordPos{Fid: 0, Offset: 0, Size: uint32(size1)}, pos)
+
+	// 等待新的写入
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(time.Millisecond * 50)
+		enc2, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-2"), Value: []byte("value-2")})
+		_ = dataFile.Write(enc2)
+	}()
+	rec, pos, err = reader.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-2"), rec.Key)
+	assert.Equal(t, size1, pos.Offset)
+	<-done
+
+	// 只写入了一半的记录不会被读取到
+	enc3, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-3"), Value: []byte("value-3")})
+	assert.Nil(t, dataFile.Write(enc3[:5]))
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, _, err = reader.Next(shortCtx)
+	shortCancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, dataFile.Write(enc3[5:]))
+	rec, _, err = reader.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-3"), rec.Key)
+
+	// 切换到下一个数据文件，文件 id 可以不连续
+	newFile, err := OpenDataFile(dir, 3, fio.StandardIO)
+	assert.Nil(t, err)
+	enc4, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-4"), Value: []byte("value-4")})
+	assert.Nil(t, newFile.Write(enc4))
+	rec, pos, err = reader.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-4"), rec.Key)
+	assert.Equal(t, uint32(3), pos.Fid)
+	assert.Equal(t, int64(0), pos.Offset)
+
+	cursor, err := reader.Position()
+	assert.Nil(t, err)
+	assert.Equal(t, LogCursor{Fid: 3, Offset: int64(len(enc4))}, cursor)
+
+	assert.Nil(t, dataFile.Close())
+	assert.Nil(t, newFile.Close())
+}
+
+func TestLogReader_FileRemoved(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-log-reader-removed")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	enc, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	assert.Nil(t, dataFile.Write(enc))
+	assert.Nil(t, dataFile.Close())
+
+	reader := NewLogReader(dir, 0, 0)
+	defer reader.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	_, _, err = reader.Next(ctx)
+	assert.Nil(t, err)
+
+	// 模拟 merge 使用同名的新文件替换旧文件
+	fileName := GetDataFileName(dir, 0)
+	assert.Nil(t, os.Rename(fileName, fileName+".old"))
+	assert.Nil(t, os.WriteFile(fileName, enc, fio.DataFilePerm))
+	_, _, err = reader.Next(ctx)
+	assert.Equal(t, ErrLogFileRemoved, err)
+
+	// 从已经被 merge 删除的文件开始读取
+	mergeFinFile, err := OpenMergeFinishedFile(dir)
+	assert.Nil(t, err)
+	encFin, _ := EncodeLogRecord(&LogRecord{Key: []byte("merge.finished"), Value: []byte("5")})
+	assert.Nil(t, mergeFinFile.Write(encFin))
+	assert.Nil(t, mergeFinFile.Close())
+
+	reader2 := NewLogReader(dir, 2, 0)
+	defer reader2.Close()
+	_, _, err = reader2.Next(ctx)
+	assert.Equal(t, ErrLogFileRemoved, err)
+}
+
+func TestLogReader_CursorBeforeMerge(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-log-reader-merge")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	enc1, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-1"), Value: []byte("value-1")})
+	enc2, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-2"), Value: []byte("value-2")})
+	assert.Nil(t, dataFile.Write(enc1))
+	assert.Nil(t, dataFile.Write(enc2))
+	assert.Nil(t, dataFile.Close())
+
+	reader := NewLogReader(dir, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	_, _, err = reader.Next(ctx)
+	assert.Nil(t, err)
+	cursor, err := reader.Position()
+	assert.Nil(t, err)
+	assert.Nil(t, reader.Close())
+
+	// 模拟 merge 使用相同的文件 id 重写数据文件，之后重新打开游标
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 0), enc2, fio.DataFilePerm))
+	mergeFinFile, err := OpenMergeFinishedFile(dir)
+	assert.Nil(t, err)
+	encFin, _ := EncodeLogRecord(&LogRecord{Key: []byte("merge.finished"), Value: []byte("1")})
+	assert.Nil(t, mergeFinFile.Write(encFin))
+	assert.Nil(t, mergeFinFile.Close())
+
+	reader2 := NewLogReaderFromCursor(dir, cursor)
+	defer reader2.Close()
+	_, _, err = reader2.Next(ctx)
+	assert.Equal(t, ErrLogFileRemoved, err)
+
+	// merge 之后获取的游标可以继续使用
+	reader3 := NewLogReader(dir, 0, 0)
+	defer reader3.Close()
+	rec, _, err := reader3.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-2"), rec.Key)
+	cursor, err = reader3.Position()
+	assert.Nil(t, err)
+	assert.Equal(t, LogCursor{Fid: 0, Offset: int64(len(enc2)), MergeBoundary: 1}, cursor)
+	reader4 := NewLogReaderFromCursor(dir, LogCursor{Fid: 0, Offset: 0, MergeBoundary: 1})
+	defer reader4.Close()
+	rec, _, err = reader4.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-2"), rec.Key)
+}
+
+func TestLogReader_InvalidCRC(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-log-reader-crc")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardIO)
+	assert.Nil(t, err)
+	enc1, size1 := EncodeLogRecord(&LogRecord{Key: []byte("key-1"), Value: []byte("value-1")})
+	enc2, _ := EncodeLogRecord(&LogRecord{Key: []byte("key-2"), Value: []byte("value-2")})
+	corrupted := append([]byte{}, enc1...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	assert.Nil(t, dataFile.Write(corrupted))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// 最新文件末尾校验失败的记录可能还没有写完整，等待之后的写入
+	reader := NewLogReader(dir, 0, 0)
+	reader.SetPollInterval(time.Millisecond * 10)
+	defer reader.Close()
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, _, err = reader.Next(shortCtx)
+	shortCancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 之后还有数据时说明记录已经损坏
+	assert.Nil(t, dataFile.Write(enc2))
+	_, _, err = reader.Next(ctx)
+	assert.Equal(t, ErrInvalidCRC, err)
+	reader2 := NewLogReader(dir, 0, size1)
+	defer reader2.Close()
+	rec, _, err := reader2.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-2"), rec.Key)
+
+	// 已经写满的文件末尾的损坏数据不会被跳过
+	assert.Nil(t, dataFile.Write(corrupted))
+	newFile, err := OpenDataFile(dir, 1, fio.StandardIO)
+	assert.Nil(t, err)
+	assert.Nil(t, newFile.Write(enc2))
+	_, _, err = reader2.Next(ctx)
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	assert.Nil(t, dataFile.Write(enc1[:3]))
+	reader3 := NewLogReader(dir, 0, dataFile.WriteOff-3)
+	defer reader3.Close()
+	_, _, err = reader3.Next(ctx)
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	assert.Nil(t, dataFile.Close())
+	assert.Nil(t, newFile.Close())
+}
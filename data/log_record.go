@@ -3,23 +3,39 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io"
+	"time"
 )
 
 type LogRecordType = byte
 
-const maxLogRecordHeaderSize = binary.MaxVarintLen32 + 5
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64*3 + 5
+
+// 类型字节的最高位标识记录是否带有过期时间
+const logRecordExpireFlag byte = 1 << 7
+
+// 类型字节的次高位标识记录是否带有提交序列号和提交时间
+const logRecordCommitFlag byte = 1 << 6
+
 const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordRangeDeleted 范围删除标记，key 为起始 key，value 为结束 key（不包含），value 为空表示没有上界
+	LogRecordRangeDeleted
 )
 
 // 写入到数据文件中的记录
 // 之所以叫日志，是因为数据文件中的数据是追加写入的，类似日志的方式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间，UnixNano 时间戳，0 表示永不过期
+	// CommitSeq 提交的全局序列号，同一个事务中的记录相同，0 表示旧版本写入的记录没有提交信息
+	CommitSeq uint64
+	// CommitTime 提交时间，UnixNano 时间戳
+	CommitTime int64
 }
 
 // 数据内存的索引，主要描述数据在磁盘上的位置
@@ -27,6 +43,7 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id，表示存储的文件位置
 	Offset int64  // 偏移量，表示将数据存储到了文件的哪个位置
 	Size   uint32 // 标识数据在磁盘上的大小
+	Expire int64  // 过期时间，0 表示永不过期
 }
 
 type LogRecordHeader struct {
@@ -34,6 +51,9 @@ type LogRecordHeader struct {
 	recordType LogRecordType // 标识 LogRecord 类型
 	keySize    uint32        // Key 的长度
 	valueSize  uint32        // Value 的长度
+	expire     int64         // 过期时间
+	commitSeq  uint64        // 提交的全局序列号
+	commitTime int64         // 提交时间
 }
 
 type TransactionRecord struct {
@@ -44,10 +64,13 @@ type TransactionRecord struct {
 // EncodeLogRecord 对 LogRecord 实例编码
 // 返回编码后包含完日志记录的字节数组和数组长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-----------+-----------+-------------+-------------+-------------+---------------+----------------+---------+---------+
+//	| crc 校验值 | type 类型  |  key size   | value size  | expire 可选  | commit seq 可选 | commit time 可选 |   key   |  value  |
+//	+-----------+-----------+-------------+-------------+-------------+---------------+----------------+---------+---------+
+//	    4字节       1字节      变长（最大5）   变长（最大5）  变长（最大10）    变长（最大10）     变长（最大10）       变长      变长
+//
+// 只有设置了过期时间的记录才会写入 expire 字段，并在 type 的最高位做标记，兼容旧的数据文件
+// 只有设置了提交序列号的记录才会写入 commit seq 和 commit time 字段，并在 type 的次高位做标记
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 
 	// 初始化一个 header 部分的字节数组
@@ -55,12 +78,25 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 
 	// 第五个字节存储 Tpye
 	header[4] = logRecord.Type
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	if logRecord.CommitSeq > 0 {
+		header[4] |= logRecordCommitFlag
+	}
 
 	// 5 字节之后，存储的是 key 和 value 的长度信息
 	// 使用变长类型，节省空间
 	var index = 5
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	if logRecord.CommitSeq > 0 {
+		index += binary.PutVarint(header[index:], int64(logRecord.CommitSeq))
+		index += binary.PutVarint(header[index:], logRecord.CommitTime)
+	}
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
@@ -78,30 +114,119 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// DecodeLogRecord 从字节数组的起始位置解码一条完整的日志记录
+// 返回 LogRecord 实例和字节长度，数据不完整时返回 io.ErrUnexpectedEOF
+func DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	if len(buf) == 0 {
+		return nil, 0, io.EOF
+	}
+	if !isLogRecordHeaderComplete(buf) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	header, headerSize := decodeLogRecordHeader(buf)
+	if header == nil {
+		return nil, 0, io.EOF
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, 0, io.EOF
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+	if int64(len(buf)) < recordSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	logRecord := &LogRecord{
+		Type:       header.recordType,
+		Expire:     header.expire,
+		CommitSeq:  header.commitSeq,
+		CommitTime: header.commitTime,
+	}
+	if keySize > 0 || valueSize > 0 {
+		logRecord.Key = buf[headerSize : headerSize+keySize]
+		logRecord.Value = buf[headerSize+keySize : recordSize]
+	}
+
+	// 校验数据的有效性
+	crc := getLogRecordCRC(logRecord, buf[crc32.Size:headerSize])
+	if crc != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, recordSize, nil
+}
+
+// DecodeLogRecordSize 根据字节数组起始位置的头部计算日志记录的总长度，头部不完整时返回 false
+func DecodeLogRecordSize(buf []byte) (int64, bool) {
+	if !isLogRecordHeaderComplete(buf) {
+		return 0, false
+	}
+	header, headerSize := decodeLogRecordHeader(buf)
+	return headerSize + int64(header.keySize) + int64(header.valueSize), true
+}
+
 // EncodeLogRecordPos 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire > 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 	return buf[:index]
 }
 
+// 判断字节数组中是否包含完整的日志记录头部
+func isLogRecordHeaderComplete(buf []byte) bool {
+	if len(buf) <= 5 {
+		return false
+	}
+	fields := 2
+	if buf[4]&logRecordExpireFlag != 0 {
+		fields++
+	}
+	if buf[4]&logRecordCommitFlag != 0 {
+		fields += 2
+	}
+	index := 5
+	for i := 0; i < fields; i++ {
+		_, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return false
+		}
+		index += n
+	}
+	return true
+}
+
 func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
 	offset, n := binary.Varint(buf[index:])
-	index += n;
-	size, _ := binary.Varint(buf[index:]) 
+	index += n
+	size, n := binary.Varint(buf[index:])
+	index += n
+	// 过期时间是可选的，旧版本编码的位置信息中不存在
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
-		Size: uint32(size),
+		Size:   uint32(size),
+		Expire: expire,
 	}
 }
 
+// IsExpired 判断位置索引对应的数据是否已经过期
+func (pos *LogRecordPos) IsExpired() bool {
+	return pos.Expire > 0 && pos.Expire <= time.Now().UnixNano()
+}
+
 // 对字节数组中的 Header 信息进行解码
 func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	if len(buf) <= 4 {
@@ -110,7 +235,7 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ (logRecordExpireFlag | logRecordCommitFlag),
 	}
 
 	var index = 5
@@ -125,6 +250,23 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
+
+	// 取出提交序列号和提交时间
+	if buf[4]&logRecordCommitFlag != 0 {
+		commitSeq, n := binary.Varint(buf[index:])
+		header.commitSeq = uint64(commitSeq)
+		index += n
+		commitTime, n := binary.Varint(buf[index:])
+		header.commitTime = commitTime
+		index += n
+	}
+
 	return header, int64(index)
 }
 
@@ -2,6 +2,7 @@ package data
 
 import (
 	"hash/crc32"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -102,3 +103,79 @@ func Test_getLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+// 带有过期时间的日志记录编解码
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, n, size+int64(len(rec.Key)+len(rec.Value)))
+	assert.Equal(t, h.crc, getLogRecordCRC(rec, res[crc32.Size:size]))
+}
+
+func TestEncodeLogRecord_Commit(t *testing.T) {
+	rec := &LogRecord{
+		Key:        []byte("name"),
+		Value:      []byte("bitcask-go"),
+		Type:       LogRecordDeleted,
+		Expire:     1700000000000000000,
+		CommitSeq:  42,
+		CommitTime: 1700000000000000001,
+	}
+	res, n := EncodeLogRecord(rec)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, rec.CommitSeq, h.commitSeq)
+	assert.Equal(t, rec.CommitTime, h.commitTime)
+	assert.Equal(t, n, size+int64(len(rec.Key)+len(rec.Value)))
+
+	decoded, _, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, decoded)
+}
+
+// 位置信息编解码
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+
+	pos2 := &LogRecordPos{Fid: 2, Offset: 200, Size: 30, Expire: 1700000000000000000}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
+}
+
+// 从字节数组中解码日志记录
+func TestDecodeLogRecord(t *testing.T) {
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask-go"),
+		Type:  LogRecordNormal,
+	}
+	res, n := EncodeLogRecord(rec)
+
+	decoded, size, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, n, size)
+	assert.Equal(t, rec, decoded)
+
+	// 数据不完整
+	_, _, err = DecodeLogRecord(res[:n-1])
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 数据被篡改
+	res[n-1] = 'x'
+	_, _, err = DecodeLogRecord(res)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
@@ -0,0 +1,467 @@
+package datatype
+
+import (
+	bitcask "bitcask-kv"
+	"bitcask-kv/utils"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// 导出时每导出多少个 key 报告一次进度
+	exportProgressInterval = 10000
+	// 数据使用 base64 编码时 encoding 字段的值
+	exportEncodingBase64 = "base64"
+)
+
+// 导出时使用的数据类型名称
+var dataTypeNames = map[DataType]string{
+	String: "string",
+	Hash:   "hash",
+	Set:    "set",
+	List:   "list",
+	Zset:   "zset",
+}
+
+// 按数据类型导出的一个 key，encoding 为 base64 时其中所有的 key、value、field、member 和 element 都使用 base64 编码
+type exportEntry struct {
+	Key      string        `json:"key"`
+	Type     string        `json:"type"`
+	Encoding string        `json:"encoding,omitempty"`
+	Expire   int64         `json:"expire,omitempty"`
+	Value    string        `json:"value,omitempty"`    // String
+	Fields   []exportField `json:"fields,omitempty"`   // Hash
+	Members  []string      `json:"members,omitempty"`  // Set
+	Elements []string      `json:"elements,omitempty"` // List，从左到右的顺序
+	Scores   []exportScore `json:"scores,omitempty"`   // Zset
+}
+
+type exportField struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type exportScore struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// 从数据库中读取出的一个 key 的全部数据
+type typedValue struct {
+	key    []byte
+	typ    DataType
+	expire int64
+	value  []byte    // String
+	fields [][]byte  // Hash 的 field，Set 的 member，List 的 element，Zset 的 member
+	values [][]byte  // Hash 的 value
+	scores []float64 // Zset 的 score
+}
+
+// Export 按数据类型将所有的 key 以 JSON Lines 的格式写入到 w 中，返回导出的 key 数量
+// 每一行是一个 key 的全部数据，例如 Hash 的所有 field 和 value、Zset 的所有 member 和 score，而不是内部的编码。
+// 在快照上遍历，导出期间的写入不会影响导出的结果，已经过期和没有数据的 key 会被跳过
+func (dts *DataTypeService) Export(w io.Writer, opts bitcask.ExportOptions) (int, error) {
+	snapshot := dts.db.Snapshot()
+	defer snapshot.Release()
+	it := snapshot.NewIterator(bitcask.IteratorOptions{Prefix: opts.Prefix})
+	defer it.Close()
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+
+	// 已经导出的 key 的内部 key 前缀，内部 key 排在 key 之后，遍历时跳过
+	var internalPrefixes [][]byte
+	var count int
+	for ; it.Valid(); it.Next() {
+		key := it.Key()
+		if isInternalKey(key, &internalPrefixes) {
+			continue
+		}
+		encValue, err := it.Value()
+		if err != nil {
+			return count, err
+		}
+
+		tv, err := readTypedValue(snapshot, key, encValue)
+		if err != nil {
+			return count, err
+		}
+		if tv == nil {
+			continue
+		}
+		if tv.typ != String {
+			internalPrefixes = append(internalPrefixes, internalKeyPrefix(key, decodeMetadata(encValue).version))
+		}
+		if err := encoder.Encode(tv.toEntry(opts.Base64)); err != nil {
+			return count, err
+		}
+
+		count++
+		if opts.Progress != nil && count%exportProgressInterval == 0 {
+			opts.Progress(count)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+	return count, nil
+}
+
+// 是否为已经导出的 key 的内部 key，同时移除之后的 key 不可能再匹配的前缀
+func isInternalKey(key []byte, prefixes *[][]byte) bool {
+	var internal bool
+	active := (*prefixes)[:0]
+	for _, prefix := range *prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			internal = true
+		} else if bytes.Compare(key, prefix) > 0 {
+			continue
+		}
+		active = append(active, prefix)
+	}
+	*prefixes = active
+	return internal
+}
+
+// 同一个 key 的所有内部 key 都以 key 和元数据中的版本号开头
+func internalKeyPrefix(key []byte, version int64) []byte {
+	buf := make([]byte, len(key)+8)
+	copy(buf, key)
+	binary.LittleEndian.PutUint64(buf[len(key):], uint64(version))
+	return buf
+}
+
+// 读取一个 key 的全部数据，不是数据类型的 key、已经过期或者没有数据时返回 nil
+func readTypedValue(snapshot *bitcask.Snapshot, key []byte, encValue []byte) (*typedValue, error) {
+	if len(encValue) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UnixNano()
+
+	if encValue[0] == String {
+		expire, n := binary.Varint(encValue[1:])
+		if n <= 0 || expire > 0 && expire <= now {
+			return nil, nil
+		}
+		return &typedValue{key: key, typ: String, expire: expire, value: encValue[1+n:]}, nil
+	}
+
+	if !isMetadata(encValue) {
+		return nil, nil
+	}
+	meta := decodeMetadata(encValue)
+	if meta.size == 0 || meta.expire != 0 && meta.expire <= now {
+		return nil, nil
+	}
+	tv := &typedValue{key: key, typ: meta.dataType, expire: meta.expire}
+	prefix := internalKeyPrefix(key, meta.version)
+
+	// List 的内部 key 中下标使用小端序编码，按照下标依次读取才能保证顺序
+	if meta.dataType == List {
+		for index := meta.head; index < meta.tail; index++ {
+			lk := &listInternalKey{key: key, version: meta.version, index: index}
+			element, err := snapshot.Get(lk.encode())
+			if err != nil {
+				return nil, err
+			}
+			tv.fields = append(tv.fields, element)
+		}
+		return tv, nil
+	}
+
+	it := snapshot.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		internalKey := it.Key()
+		value, err := it.Value()
+		if err != nil {
+			return nil, err
+		}
+		switch meta.dataType {
+		case Hash:
+			tv.fields = append(tv.fields, internalKey[len(prefix):])
+			tv.values = append(tv.values, value)
+		case Set:
+			// key + version + member + member size
+			tv.fields = append(tv.fields, internalKey[len(prefix):len(internalKey)-4])
+		case Zset:
+			// 指向 score 的内部 key 的 value 为空，只需要读取 key + version + member -> score
+			if len(value) == 0 {
+				continue
+			}
+			tv.fields = append(tv.fields, internalKey[len(prefix):])
+			tv.scores = append(tv.scores, utils.FloatFromBytes(value))
+		}
+	}
+	return tv, nil
+}
+
+// 是否为完整的元数据编码，避免将其他数据误认为元数据
+func isMetadata(buf []byte) bool {
+	if buf[0] != Hash && buf[0] != Set && buf[0] != List && buf[0] != Zset {
+		return false
+	}
+	index := 1
+	varints := 3
+	for i := 0; i < varints; i++ {
+		_, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return false
+		}
+		index += n
+	}
+	if buf[0] == List {
+		for i := 0; i < 2; i++ {
+			_, n := binary.Uvarint(buf[index:])
+			if n <= 0 {
+				return false
+			}
+			index += n
+		}
+	}
+	return index == len(buf)
+}
+
+func (tv *typedValue) toEntry(forceBase64 bool) *exportEntry {
+	useBase64 := forceBase64 || !tv.validUTF8()
+	encode := func(b []byte) string {
+		if useBase64 {
+			return base64.StdEncoding.EncodeToString(b)
+		}
+		return string(b)
+	}
+
+	entry := &exportEntry{Key: encode(tv.key), Type: dataTypeNames[tv.typ], Expire: tv.expire}
+	if useBase64 {
+		entry.Encoding = exportEncodingBase64
+	}
+	switch tv.typ {
+	case String:
+		entry.Value = encode(tv.value)
+	case Hash:
+		for i, field := range tv.fields {
+			entry.Fields = append(entry.Fields, exportField{Field: encode(field), Value: encode(tv.values[i])})
+		}
+	case Set:
+		for _, member := range tv.fields {
+			entry.Members = append(entry.Members, encode(member))
+		}
+	case List:
+		for _, element := range tv.fields {
+			entry.Elements = append(entry.Elements, encode(element))
+		}
+	case Zset:
+		for i, member := range tv.fields {
+			entry.Scores = append(entry.Scores, exportScore{Member: encode(member), Score: tv.scores[i]})
+		}
+	}
+	return entry
+}
+
+func (tv *typedValue) validUTF8() bool {
+	if !utf8.Valid(tv.key) || !utf8.Valid(tv.value) {
+		return false
+	}
+	for _, field := range tv.fields {
+		if !utf8.Valid(field) {
+			return false
+		}
+	}
+	for _, value := range tv.values {
+		if !utf8.Valid(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Import 读取 Export 导出的数据并写入到数据库中，返回导入的 key 数量
+// 使用 WriteBatch 批量写入内部编码之后的数据，已经存在的 key 会被覆盖，已经过期的 key 会被跳过。
+// 一个 key 的数据超过一个批次时，元数据在所有数据写入之后最后写入
+func (dts *DataTypeService) Import(r io.Reader, opts bitcask.ImportOptions) (int, error) {
+	if opts.BatchNum == 0 {
+		opts.BatchNum = bitcask.DefaultImportOptions.BatchNum
+	}
+	wb := dts.db.NewWriteBatch(bitcask.WriteBatchOptions{MaxBatchNum: opts.BatchNum, SyncWrites: opts.SyncWrites})
+	// staged 为已经全部写入批次的 key 数量，count 为已经提交的 key 数量
+	var staged, count int
+	put := func(key, value []byte) error {
+		if err := wb.Put(key, value); err != nil {
+			return err
+		}
+		if uint(wb.Len()) < opts.BatchNum {
+			return nil
+		}
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		count = staged
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	decoder.DisallowUnknownFields()
+	for line := 1; ; line++ {
+		entry := &exportEntry{}
+		if err := decoder.Decode(entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return count, fmt.Errorf("%w: record %d: %v", bitcask.ErrInvalidImportData, line, err)
+		}
+		tv, err := entry.decode()
+		if err != nil {
+			return count, fmt.Errorf("%w: record %d: %v", bitcask.ErrInvalidImportData, line, err)
+		}
+		if tv.expire > 0 && tv.expire <= time.Now().UnixNano() {
+			continue
+		}
+		if err := tv.write(put); err != nil {
+			return count, err
+		}
+		staged++
+	}
+
+	if wb.Len() > 0 {
+		if err := wb.Commit(); err != nil {
+			return count, err
+		}
+	}
+	count = staged
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+	return count, nil
+}
+
+func (entry *exportEntry) decode() (*typedValue, error) {
+	var typ DataType
+	var found bool
+	for t, name := range dataTypeNames {
+		if name == entry.Type {
+			typ, found = t, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown type %q", entry.Type)
+	}
+
+	var decodeErr error
+	decode := func(s string) []byte {
+		if entry.Encoding != exportEncodingBase64 {
+			return []byte(s)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil && decodeErr == nil {
+			decodeErr = err
+		}
+		return b
+	}
+	if entry.Encoding != "" && entry.Encoding != exportEncodingBase64 {
+		return nil, fmt.Errorf("unknown encoding %q", entry.Encoding)
+	}
+
+	tv := &typedValue{key: decode(entry.Key), typ: typ, expire: entry.Expire, value: decode(entry.Value)}
+	for _, field := range entry.Fields {
+		tv.fields = append(tv.fields, decode(field.Field))
+		tv.values = append(tv.values, decode(field.Value))
+	}
+	for _, member := range entry.Members {
+		tv.fields = append(tv.fields, decode(member))
+	}
+	for _, element := range entry.Elements {
+		tv.fields = append(tv.fields, decode(element))
+	}
+	for _, score := range entry.Scores {
+		tv.fields = append(tv.fields, decode(score.Member))
+		tv.scores = append(tv.scores, score.Score)
+	}
+	if len(tv.key) == 0 && decodeErr == nil {
+		decodeErr = bitcask.ErrKeyIsEmpty
+	}
+	return tv, decodeErr
+}
+
+// 将一个 key 的数据按照内部编码写入，元数据最后写入
+func (tv *typedValue) write(put func(key, value []byte) error) error {
+	if tv.typ == String {
+		return put(tv.key, encodeStringValue(tv.expire, tv.value))
+	}
+
+	meta := &metadata{
+		dataType: tv.typ,
+		expire:   tv.expire,
+		version:  time.Now().UnixNano(),
+	}
+	// Hash、Set 和 Zset 中重复的 field 和 member 只写入第一个
+	seen := make(map[string]struct{})
+	isDuplicate := func(field []byte) bool {
+		if _, ok := seen[string(field)]; ok || tv.typ == List {
+			return ok
+		}
+		seen[string(field)] = struct{}{}
+		meta.size++
+		return false
+	}
+	switch tv.typ {
+	case Hash:
+		for i, field := range tv.fields {
+			if isDuplicate(field) {
+				continue
+			}
+			hk := &hashInternalKy{key: tv.key, version: meta.version, filed: field}
+			if err := put(hk.encode(), tv.values[i]); err != nil {
+				return err
+			}
+		}
+	case Set:
+		for _, member := range tv.fields {
+			if isDuplicate(member) {
+				continue
+			}
+			sk := &setInternalKey{key: tv.key, version: meta.version, member: member}
+			if err := put(sk.encode(), nil); err != nil {
+				return err
+			}
+		}
+	case List:
+		meta.head = initialListMark
+		meta.tail = initialListMark
+		for _, element := range tv.fields {
+			lk := &listInternalKey{key: tv.key, version: meta.version, index: meta.tail}
+			if err := put(lk.encode(), element); err != nil {
+				return err
+			}
+			meta.tail++
+			meta.size++
+		}
+	case Zset:
+		for i, member := range tv.fields {
+			if isDuplicate(member) {
+				continue
+			}
+			zk := &zsetInternalKey{key: tv.key, version: meta.version, member: member, score: tv.scores[i]}
+			if err := put(zk.encodeWithMember(), utils.Float64ToBytes(zk.score)); err != nil {
+				return err
+			}
+			if err := put(zk.encodeWithScore(), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return put(tv.key, meta.encode())
+}
@@ -0,0 +1,99 @@
+package datatype
+
+import (
+	bitcask "bitcask-kv"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataTypeService_ExportImport(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-export")
+	opts.DirPath = dir
+	dts, err := NewDataTypeService(opts)
+	assert.Nil(t, err)
+	defer dts.Close()
+
+	assert.Nil(t, dts.Set([]byte("str"), time.Hour, []byte("value")))
+	_, err = dts.HSet([]byte("hash"), []byte("f1"), []byte("v1"))
+	assert.Nil(t, err)
+	_, err = dts.HSet([]byte("hash"), []byte("f2"), []byte{0xff})
+	assert.Nil(t, err)
+	_, err = dts.SAdd([]byte("set"), []byte("m1"))
+	assert.Nil(t, err)
+	_, err = dts.SAdd([]byte("set"), []byte("m2"))
+	assert.Nil(t, err)
+	for _, element := range []string{"b", "c"} {
+		_, err = dts.RPush([]byte("list"), []byte(element))
+		assert.Nil(t, err)
+	}
+	_, err = dts.LPush([]byte("list"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = dts.ZAdd([]byte("zset"), 1.5, []byte("m1"))
+	assert.Nil(t, err)
+	_, err = dts.ZAdd([]byte("zset"), 2, []byte("m2"))
+	assert.Nil(t, err)
+	_, err = dts.ZAdd([]byte("zset"), 3, []byte("m1"))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	count, err := dts.Export(&buf, bitcask.DefaultExportOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	exported := buf.String()
+	lines := strings.Split(strings.TrimSpace(exported), "\n")
+	assert.Equal(t, 5, len(lines))
+	assert.Contains(t, lines[0], `"key":"aGFzaA==","type":"hash","encoding":"base64"`)
+	assert.Equal(t, `{"key":"list","type":"list","elements":["a","b","c"]}`, lines[1])
+	assert.Equal(t, `{"key":"set","type":"set","members":["m1","m2"]}`, lines[2])
+	assert.Contains(t, lines[3], `"key":"str","type":"string"`)
+	assert.Contains(t, lines[3], `"value":"value"`)
+	assert.Equal(t, `{"key":"zset","type":"zset","scores":[{"member":"m1","score":3},{"member":"m2","score":2}]}`, lines[4])
+
+	opts2 := bitcask.DefaultOptions
+	dir2, _ := os.MkdirTemp("", "bitcask-go-redis-import")
+	opts2.DirPath = dir2
+	dts2, err := NewDataTypeService(opts2)
+	assert.Nil(t, err)
+	defer dts2.Close()
+
+	importOpts := bitcask.DefaultImportOptions
+	importOpts.BatchNum = 2
+	count, err = dts2.Import(&buf, importOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+
+	val, err := dts2.HGet([]byte("hash"), []byte("f2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff}, val)
+	ok, err := dts2.SIsMember([]byte("set"), []byte("m2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	element, err := dts2.LPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), element)
+	element, err = dts2.RPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), element)
+	score, err := dts2.ZScore([]byte("zset"), []byte("m1"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(3), score)
+	val, err = dts2.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 导入之后再次导出的结果相同
+	_, err = dts2.RPush([]byte("list"), []byte("c"))
+	assert.Nil(t, err)
+	_, err = dts2.LPush([]byte("list"), []byte("a"))
+	assert.Nil(t, err)
+	var buf2 bytes.Buffer
+	_, err = dts2.Export(&buf2, bitcask.DefaultExportOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, exported, buf2.String())
+}
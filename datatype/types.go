@@ -25,9 +25,11 @@ const (
 type DataTypeService struct {
 	db *bitcask.DB
 }
+
 func (dts *DataTypeService) Close() error {
 	return dts.db.Close()
 }
+
 // NewDataTypeService 初始化数据类型服务
 func NewDataTypeService(options bitcask.Options) (*DataTypeService, error) {
 	db, err := bitcask.Open(options)
@@ -83,21 +85,25 @@ func (dts *DataTypeService) Set(key []byte, ttl time.Duration, value []byte) err
 		return nil
 	}
 
-	// 编码 value ：type + expire + payload
-	buf := make([]byte, binary.MaxVarintLen64+1)
-	buf[0] = String
-	var index = 1
 	var expire int64 = 0
 	if ttl != 0 {
 		expire = time.Now().Add(ttl).UnixNano()
 	}
+
+	// 调用存储接口写入数据
+	return dts.db.Put(key, encodeStringValue(expire, value))
+}
+
+// 编码 value ：type + expire + payload
+func encodeStringValue(expire int64, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+	buf[0] = String
+	var index = 1
 	index += binary.PutVarint(buf[index:], expire)
 	encValue := make([]byte, index+len(value))
 	copy(encValue[:index], buf[:index])
 	copy(encValue[index:], value)
-
-	// 调用存储接口写入数据
-	return dts.db.Put(key, encValue)
+	return encValue
 }
 
 func (dts *DataTypeService) Get(key []byte) ([]byte, error) {
@@ -5,6 +5,7 @@ import (
 	"bitcask-kv/fio"
 	"bitcask-kv/index"
 	"bitcask-kv/utils"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/flock"
@@ -22,36 +24,67 @@ import (
 const (
 	seqNoKey     = "seq.no"
 	fileLockName = "flock"
+
+	// MultiGet 合并读取时单次读取的最大字节数
+	maxMultiGetReadSize = 4 * 1024 * 1024
 )
 
 // DB bitcask 存储引擎实例
 type DB struct {
-	options         Options
-	mtx             *sync.RWMutex
-	fileIds         []int                     // 文件 id，只能在加载索引的时候使用，不能在其他地方更新或使用
-	activeFile      *data.DataFile            // 当前的活跃文件，可以用于写入
-	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只能用于读取
-	index           index.Indexer             // 内存索引
-	seqNo           uint64                    // 事务序列号
-	isMerging       bool                      // 是否正在 merge
-	seqNoFileExists bool                      // 存储事务序列号文件是否存在
-	isInitial       bool                      // 是否第一次初始化此数据目录
-	filelock        *flock.Flock              // 文件锁保证多进程之间的互斥
-	bytesWrite      uint                      // 累计写了多少个字节
-	reclaimSize     int64                     // 有多少数据是无效的
-	mergeStopChan 	chan struct{} 			  // 用于控制后台持久化协程关闭的通道
+	options           Options
+	mtx               *sync.RWMutex
+	fileIds           []int                     // 文件 id，只能在加载索引的时候使用，不能在其他地方更新或使用
+	activeFile        *data.DataFile            // 当前的活跃文件，可以用于写入
+	olderFiles        map[uint32]*data.DataFile // 旧的数据文件，只能用于读取
+	index             index.Indexer             // 内存索引
+	seqNo             uint64                    // 事务序列号
+	commitSeq         uint64                    // 最近一次提交的全局序列号，包括非事务的写入
+	reservedCommitSeq uint64                    // 已经分配的最大提交序列号，可能还没有写入
+	version           uint64                    // 写入版本号，每次写入都会递增，仅在内存中维护
+	activeTxnNum      int                       // 正在进行中的乐观事务数量
+	modifiedKeys      map[string]uint64         // 有事务进行时，记录每个 key 最近一次被修改的版本号
+	txnId             uint64                    // 事务 id 生成器
+	lockManager       *lockManager              // 悲观事务的 key 锁管理器
+	isMerging         bool                      // 是否正在 merge
+	seqNoFileExists   bool                      // 存储事务序列号文件是否存在
+	isInitial         bool                      // 是否第一次初始化此数据目录
+	filelock          *flock.Flock              // 文件锁保证多进程之间的互斥
+	bytesWrite        uint                      // 累计写了多少个字节
+	reclaimSize       int64                     // 有多少数据是无效的
+	mergeStopChan     chan struct{}             // 用于控制后台持久化协程关闭的通道
+	expireQueue       *expireQueue              // 按过期时间排序的 key 队列
+	closeChan         chan struct{}             // 数据库关闭时关闭，用于通知后台过期清理和 Watch 协程退出
+	expiredKeyNum     uint64                    // 后台累计清理的过期 key 数量
+	mergeBoundary     uint32                    // 小于此 id 的数据文件是 merge 重写生成的
+	watchMtx          *sync.Mutex
+	watchNotify       chan struct{}                        // 有新的写入时关闭，用于唤醒等待中的 Watch 协程和复制连接
+	isReplica         bool                                 // 是否为复制的从节点，从节点只能读取
+	pendingTxnRecords map[uint64][]*data.TransactionRecord // 只读实例加载到的还未提交的事务数据，等待 Refresh 读取到事务完成记录
+	recoveryReport    RecoveryReport                       // 打开数据库时从数据文件加载索引的恢复结果
+	isScrubbing       bool                                 // 是否正在校验旧数据文件
+	scrubPasses       atomic.Uint64                        // 完成校验所有旧数据文件的次数
+	scrubbedBytes     atomic.Int64                         // 本轮已经校验的字节数
+	scrubTotalBytes   atomic.Int64                         // 本轮需要校验的字节数
+	scrubCorruptions  atomic.Uint64                        // 校验累计发现的损坏数据数量
 }
 
 // Stat 存储引擎统计信息
 type Stat struct {
-	KeyNum          uint  // key总数
-	DataFileNum     uint  // 数据文件的数量
-	ReclaimableSize int64 //可以进行merge回收的字节数
-	DiskSize        int64 // 数据目录所占磁盘空间
+	KeyNum          uint   // key总数
+	DataFileNum     uint   // 数据文件的数量
+	ReclaimableSize int64  //可以进行merge回收的字节数
+	DiskSize        int64  // 数据目录所占磁盘空间
+	ExpireKeyNum    uint   // 过期队列中等待检查的 key 数量，可能包含已经被覆盖的 key
+	ExpiredKeyNum   uint64 // 后台累计清理的过期 key 数量
+	CommitSeq       uint64 // 最近一次提交的全局序列号，可以用于时间点恢复
+	ScrubPasses     uint64 // 完成校验所有旧数据文件的次数
+	ScrubbedBytes   int64  // 当前或最近一轮校验已经校验的字节数
+	ScrubTotalBytes int64  // 当前或最近一轮校验需要校验的字节数
+	ScrubErrors     uint64 // 校验累计发现的损坏数据数量
 }
 
 // Open 打开 bitcask 存储引擎实例
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 	// 对用户传入的配置项进行一个校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
@@ -60,58 +93,97 @@ func Open(options Options) (*DB, error) {
 	var isInitial bool
 	// 判断数据目录是否存在，如果不存在的话，则创建这个目录
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读实例不能创建数据目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.Mkdir(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// 判断当前数据目录是否在使用
-	filelock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := filelock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+	// 判断当前数据目录是否在使用，只读实例不修改数据目录，不需要持有文件锁
+	var filelock *flock.Flock
+	if !options.ReadOnly {
+		filelock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err := filelock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
 	}
 
 	// 初始化 DB 实例结构体
 	db := &DB{
-		options:    options,
-		mtx:        new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		filelock:   filelock,
+		options:       options,
+		mtx:           new(sync.RWMutex),
+		olderFiles:    make(map[uint32]*data.DataFile),
+		index:         index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		isInitial:     isInitial,
+		filelock:      filelock,
 		mergeStopChan: make(chan struct{}),
+		expireQueue:   newExpireQueue(),
+		modifiedKeys:  make(map[string]uint64),
+		lockManager:   newLockManager(),
+		closeChan:     make(chan struct{}),
+		watchMtx:      new(sync.Mutex),
+		isReplica:     options.replica,
 	}
 
-	// 加载 merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
-	}
+	// 打开失败时关闭已经打开的文件并释放文件锁，修复数据目录之后可以重新打开
+	defer func() {
+		if err != nil {
+			db.closeFilesOnError()
+		}
+	}()
 
-	// 加载数据文件
-	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+	// 加载 merge 数据目录，只读实例继续使用 merge 之前的数据文件，由写入进程在下次启动时替换
+	if !options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
 	}
 
-	// B+树不需要从数据文件中建造索引
-	if options.IndexType != BPTree {
-		if err := db.loadIndexFromHintFile(); err != nil {
+	// 时间点恢复需要加载归档的数据文件，并从数据文件中重新构建索引
+	if options.RecoverUntil != nil {
+		if err := db.loadRecoverFiles(); err != nil {
 			return nil, err
 		}
-
 		if err := db.loadIndexFromDataFiles(); err != nil {
 			return nil, err
 		}
+	} else {
+		// 加载数据文件
+		if err := db.loadDataFiles(); err != nil {
+			return nil, err
+		}
 
-		if db.options.MMapAtStartup {
-			if err := db.resetIoType(); err != nil {
+		// 加载 merge 重写的数据文件范围
+		if err := db.loadMergeBoundary(); err != nil {
+			return nil, err
+		}
+
+		// B+树不需要从数据文件中建造索引
+		if options.IndexType != BPTree {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
+
+			if err := db.loadIndexFromDataFiles(); err != nil {
 				return nil, err
 			}
+
+			if db.options.MMapAtStartup {
+				if err := db.resetIoType(); err != nil {
+					return nil, err
+				}
+			}
 		}
+
+		db.loadCommitSeq()
 	}
 
 	if options.IndexType == BPTree {
@@ -127,26 +199,57 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
-	// 启动自动检查
-    go db.startMergeCheck()
+	// 将带有过期时间的 key 加入过期队列
+	db.loadExpireQueue()
+
+	// merge 时打开的临时实例只用于写入，不启动任何后台任务
+	if options.noBackgroundTasks {
+		return db, nil
+	}
+
+	// 启动自动检查，从节点的数据全部来自主节点，只读实例不能写入，都不进行 merge 和过期清理
+	if !db.isReplica && !options.ReadOnly {
+		go db.startMergeCheck()
+		go db.startExpireCheck()
+	}
+	if options.ReadOnly && options.RecoverUntil == nil && options.ReadOnlyRefreshInterval > 0 {
+		go db.startRefreshCheck()
+	}
+	if options.ScrubInterval > 0 {
+		go db.startScrubCheck()
+	}
 
 	return db, nil
 }
 
 // 自动检查是否需要 merge
 func (db *DB) startMergeCheck() error {
-    ticker := time.NewTicker(db.options.mergeCheckInterval)
+	ticker := time.NewTicker(db.options.mergeCheckInterval)
 	defer ticker.Stop()
-    for {
+	for {
 		select {
-		case <- ticker.C:
+		case <-ticker.C:
 			if err := db.Merge(); err != nil {
 				return err
 			}
-		case <- db.mergeStopChan:
+		case <-db.mergeStopChan:
 			return nil
 		}
-		
+
+	}
+}
+
+// 打开数据库失败时释放已经持有的资源，忽略关闭时的错误
+func (db *DB) closeFilesOnError() {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	if db.filelock != nil {
+		_ = db.filelock.Unlock()
 	}
 }
 
@@ -154,11 +257,21 @@ func (db *DB) startMergeCheck() error {
 func (db *DB) Close() error {
 
 	defer func() {
+		if db.filelock == nil {
+			return
+		}
 		if err := db.filelock.Unlock(); err != nil {
 			panic(fmt.Sprintf("falied to unlock the directory %v", err))
 		}
 	}()
 
+	// 先停止后台过期清理和 Watch，避免其在关闭之后继续读写
+	select {
+	case <-db.closeChan:
+	default:
+		close(db.closeChan)
+	}
+
 	if db.activeFile == nil {
 		return nil
 	}
@@ -171,25 +284,11 @@ func (db *DB) Close() error {
 		return err
 	}
 
-	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
-	}
-	if err := seqNoFile.Close(); err != nil {
-		return err
+	// 保存当前事务序列号，只读实例不修改数据目录
+	if !db.options.ReadOnly {
+		if err := writeSeqNoFile(db.options.DirPath, db.seqNo); err != nil {
+			return err
+		}
 	}
 
 	// 关闭当前的活跃文件
@@ -207,6 +306,32 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// 编码事务序列号文件的内容
+func encodeSeqNo(seqNo uint64) []byte {
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	return encRecord
+}
+
+// 保存事务序列号到 dirPath 目录下的文件中
+func writeSeqNoFile(dirPath string, seqNo uint64) error {
+	seqNoFile, err := data.OpenSeqNoFile(dirPath)
+	if err != nil {
+		return err
+	}
+
+	if err := seqNoFile.Write(encodeSeqNo(seqNo)); err != nil {
+		return err
+	}
+	if err := seqNoFile.Sync(); err != nil {
+		return err
+	}
+	return seqNoFile.Close()
+}
+
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
@@ -222,7 +347,7 @@ func (db *DB) Sync() error {
 // Stat 返回数据库相关的信息
 func (db *DB) Stat() *Stat {
 	db.mtx.RLock()
-	defer db.mtx.Unlock()
+	defer db.mtx.RUnlock()
 
 	var dataFiles = uint(len(db.olderFiles))
 	if db.activeFile != nil {
@@ -238,40 +363,151 @@ func (db *DB) Stat() *Stat {
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        dirSize,
+		ExpireKeyNum:    uint(db.expireQueue.len()),
+		ExpiredKeyNum:   db.expiredKeyNum,
+		CommitSeq:       db.commitSeq,
+		ScrubPasses:     db.scrubPasses.Load(),
+		ScrubbedBytes:   db.scrubbedBytes.Load(),
+		ScrubTotalBytes: db.scrubTotalBytes.Load(),
+		ScrubErrors:     db.scrubCorruptions.Load(),
 	}
 }
 
 func (db *DB) Backup(dir string) error {
 	db.mtx.RLock()
-	defer db.mtx.Unlock()
+	defer db.mtx.RUnlock()
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
 
 // Put 写入 Key/Value 数据，key 不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.putWithLock(key, value, 0)
+}
+
+// PutWithTTL 写入带有过期时间的 Key/Value 数据，过期之后 key 将不可见
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.putWithLock(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// PutIfAbsent 仅当 key 不存在（或已过期）时写入数据，返回是否写入成功
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	_, err := db.getValue(key)
+	if err == nil {
+		return false, nil
+	}
+	if err != ErrKeyNotFound {
+		return false, err
+	}
+	if err := db.put(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap 仅当 key 存在且当前值等于 oldValue 时，将其更新为 newValue，返回是否更新成功
+func (db *DB) CompareAndSwap(key []byte, oldValue, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	matched, err := db.valueEquals(key, oldValue)
+	if err != nil || !matched {
+		return false, err
+	}
+	if err := db.put(key, newValue, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete 仅当 key 存在且当前值等于 oldValue 时删除 key，返回是否删除成功
+func (db *DB) CompareAndDelete(key []byte, oldValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	matched, err := db.valueEquals(key, oldValue)
+	if err != nil || !matched {
+		return false, err
+	}
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *DB) putWithLock(key []byte, value []byte, expire int64) error {
 	// 判断 key 是否为空
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.put(key, value, expire)
+}
 
+// 写入数据并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) put(key []byte, value []byte, expire int64) error {
 	// 构造 LogRecord 结构体
 	logRecode := data.LogRecord{
-		Key:   logRecordKeyWithReq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithReq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
+	logRecode.CommitSeq, logRecode.CommitTime = db.nextCommit()
 
 	// 追加写入到当前的活跃文件当中
-	pos, err := db.appendLogRecordWithLock(&logRecode)
+	pos, err := db.appendLogRecord(&logRecode)
 	if err != nil {
 		return err
 	}
+	db.version++
 
 	// 更新内存的索引
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
-	return err
+	db.markModified(key)
+
+	// 加入过期队列，由后台协程清理
+	if expire > 0 {
+		db.expireQueue.push(key, expire)
+	}
+	return nil
+}
+
+// TTL 获取 key 剩余的存活时间，返回 0 表示 key 没有设置过期时间
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return 0, ErrKeyNotFound
+	}
+	if logRecordPos.Expire == 0 {
+		return 0, nil
+	}
+	return time.Until(time.Unix(0, logRecordPos.Expire)), nil
 }
 
 // Delete 根据 key 删除对应的数据
@@ -280,7 +516,14 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.delete(key)
+}
 
+// 写入删除记录并移除内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) delete(key []byte) error {
 	// 先检查 key 是否存在，如果不存在直接返回
 	if pos := db.index.Get(key); pos == nil {
 		return nil
@@ -291,12 +534,14 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithReq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
+	logRecord.CommitSeq, logRecord.CommitTime = db.nextCommit()
 
 	// 然后写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
+	db.version++
 	db.reclaimSize += int64(pos.Size)
 
 	// 将索引中对应的 key 数据删除
@@ -307,6 +552,87 @@ func (db *DB) Delete(key []byte) error {
 	if oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
+	db.markModified(key)
+	return nil
+}
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，end 为空表示删除 start 之后的所有 key
+// 只会写入一条范围删除标记，而不是为每个 key 写入删除记录
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if len(start) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.deleteRange(start, end)
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.deleteRange(prefix, prefixUpperBound(prefix))
+}
+
+// 写入范围删除标记并移除范围内的内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRange(start []byte, end []byte) error {
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithReq(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+	logRecord.CommitSeq, logRecord.CommitTime = db.nextCommit()
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.version++
+	db.reclaimSize += int64(pos.Size)
+
+	for _, key := range db.deleteIndexRange(start, end) {
+		db.markModified(key)
+	}
+	return nil
+}
+
+// 从内存索引中删除 [start, end) 范围内的 key，返回被删除的 key
+func (db *DB) deleteIndexRange(start []byte, end []byte) [][]byte {
+	// 先收集再删除，避免边遍历边修改索引
+	var keys [][]byte
+	it := db.index.Iterator(false)
+	for it.Seek(start); it.Valid(); it.Next() {
+		if len(end) > 0 && bytes.Compare(it.Key(), end) >= 0 {
+			break
+		}
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if oldPos, _ := db.index.Delete(key); oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+	}
+	return keys
+}
+
+// 计算前缀的上界，即大于所有以 prefix 为前缀的 key 的最小值，不存在时返回 nil
+func prefixUpperBound(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
 	return nil
 }
 
@@ -318,26 +644,163 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.getValue(key)
+}
+
+// MultiGet 批量读取多个 key 的数据，返回的 values 和 errs 与 keys 一一对应
+// 所有 key 的位置在同一把读锁内解析，读取时按照文件和偏移量排序，并将相邻的记录合并为一次读取
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	// 从内存索引中取出所有 key 的位置信息
+	type multiGetRequest struct {
+		idx int
+		pos *data.LogRecordPos
+	}
+	requests := make([]*multiGetRequest, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		logRecordPos := db.index.Get(key)
+		if logRecordPos == nil || logRecordPos.IsExpired() {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		requests = append(requests, &multiGetRequest{idx: i, pos: logRecordPos})
+	}
+
+	// 按照文件 id 和偏移量排序
+	sort.Slice(requests, func(i, j int) bool {
+		pi, pj := requests[i].pos, requests[j].pos
+		if pi.Fid != pj.Fid {
+			return pi.Fid < pj.Fid
+		}
+		return pi.Offset < pj.Offset
+	})
+
+	// 将同一个文件中相邻的记录合并为一次读取
+	for start := 0; start < len(requests); {
+		fid := requests[start].pos.Fid
+		runStart := requests[start].pos.Offset
+		runEnd := runStart + int64(requests[start].pos.Size)
+		end := start + 1
+		for ; end < len(requests); end++ {
+			pos := requests[end].pos
+			if pos.Fid != fid {
+				break
+			}
+			posEnd := pos.Offset + int64(pos.Size)
+			// 重复的 key 指向同一条记录
+			if posEnd <= runEnd {
+				continue
+			}
+			if pos.Offset != runEnd || posEnd-runStart > maxMultiGetReadSize {
+				break
+			}
+			runEnd = posEnd
+		}
+
+		buf, err := db.readDataFileBytes(fid, runStart, runEnd-runStart)
+		for _, req := range requests[start:end] {
+			if err != nil {
+				errs[req.idx] = err
+				continue
+			}
+			logRecord, _, err := data.DecodeLogRecord(buf[req.pos.Offset-runStart:])
+			if err != nil {
+				errs[req.idx] = err
+				continue
+			}
+			if logRecord.Type == data.LogRecordDeleted {
+				errs[req.idx] = ErrKeyNotFound
+				continue
+			}
+			values[req.idx] = logRecord.Value
+		}
+		start = end
+	}
+	return values, errs
+}
 
+// 从指定的数据文件中读取一段字节
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) readDataFileBytes(fid uint32, offset int64, n int64) ([]byte, error) {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[fid]
+	}
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	return dataFile.ReadBytes(n, offset)
+}
+
+// 查找比 fid 更大的下一个数据文件 id，数据文件 id 在 merge 之后可能不连续
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) nextDataFileId(fid uint32) (uint32, bool) {
+	var nextFid uint32
+	var found bool
+	for id := range db.olderFiles {
+		if id > fid && (!found || id < nextFid) {
+			nextFid, found = id, true
+		}
+	}
+	if db.activeFile != nil && db.activeFile.FileId > fid && (!found || db.activeFile.FileId < nextFid) {
+		nextFid, found = db.activeFile.FileId, true
+	}
+	return nextFid, found
+}
+
+// 根据 key 读取对应的 value
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) getValue(key []byte) ([]byte, error) {
 	// 从内存的数据结构中取出 key 对应的索引信息
 	logRecordPos := db.index.Get(key)
 	// 如果没有找到，说明 key 不存在索引中
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
 	}
+	// 已经过期的 key 同样视为不存在
+	if logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
 
 	// 从数据文件中取出 Value
 	return db.getValueByPosition(logRecordPos)
 }
 
+// 判断 key 当前的值是否等于 expected，key 不存在时返回 false
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) valueEquals(key []byte, expected []byte) (bool, error) {
+	value, err := db.getValue(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(value, expected), nil
+}
+
 // 获取数据库中的所有 key
 func (db *DB) ListKeys() [][]byte {
 	it := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	defer it.Close()
+	keys := make([][]byte, 0, db.index.Size())
 	for it.Rewind(); it.Valid(); it.Next() {
-		keys[idx] = it.Key()
-		idx++
+		// 跳过已经过期的 key
+		if it.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, it.Key())
 	}
 	return keys
 }
@@ -348,7 +811,12 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	defer db.mtx.RUnlock()
 
 	it := db.index.Iterator(false)
+	defer it.Close()
 	for it.Rewind(); it.Valid(); it.Next() {
+		// 跳过已经过期的 key
+		if it.Value().IsExpired() {
+			continue
+		}
 		value, err := db.getValueByPosition(it.Value())
 		if err != nil {
 			return err
@@ -388,14 +856,38 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
-	return db.appendLogRecord(logRecord)
+// 为下一次提交分配全局序列号和提交时间，同一个事务中的记录使用相同的值
+// 记录写入成功之后才会更新 db.commitSeq，悲观事务在分配之后释放锁再写入，因此记录已经分配的最大值避免重复分配
+// 在访问此方法前必须持有互斥锁
+func (db *DB) nextCommit() (uint64, int64) {
+	db.reservedCommitSeq = max(db.reservedCommitSeq, db.commitSeq) + 1
+	return db.reservedCommitSeq, time.Now().UnixNano()
+}
+
+// 检查当前实例是否可以写入，只读实例和复制的从节点都不能写入
+func (db *DB) checkWritable() error {
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+	if db.isReplica {
+		return ErrReplicaReadOnly
+	}
+	return nil
 }
 
 // 追加写数据到活跃文件中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	// 写入数据编码
+	encRecord, _ := data.EncodeLogRecord(logRecord)
+	return db.appendEncodedLogRecord(logRecord, encRecord)
+}
+
+// 追加写已经编码的数据到活跃文件中，encRecord 为 logRecord 编码之后的数据
+func (db *DB) appendEncodedLogRecord(logRecord *data.LogRecord, encRecord []byte) (*data.LogRecordPos, error) {
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
 	// 判断当前活跃数据文件是否存在，因为数据库在没有写入的时候是没有文件生成的
 	// 如果为空则初始化数据文件
 	if db.activeFile == nil {
@@ -404,8 +896,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	size := int64(len(encRecord))
 
 	// 如果写入的数据已经达到了活跃文件的阈值，则关闭活跃文件，并打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
@@ -429,6 +920,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	db.bytesWrite += uint(size)
+	if logRecord.CommitSeq > db.commitSeq {
+		db.commitSeq = logRecord.CommitSeq
+	}
 
 	// 根据用户配置决定是否持久化
 	var needSync = db.options.SyncWrites
@@ -449,7 +943,11 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
 	}
+
+	// 唤醒等待新写入的 Watch 协程
+	db.notifyWatchers()
 	return pos, nil
 }
 
@@ -477,9 +975,28 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
+	// Watch 的序列号中只能保存 32 位的文件偏移
+	if options.DataFileSize >= 1<<watchSeqOffsetBits {
+		return errors.New("database data file size must be less than 4GB")
+	}
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge radio, must between 0 and 1")
 	}
+	if options.ReadOnly && options.IndexType == BPTree {
+		return errors.New("read-only mode does not support the B+ tree index")
+	}
+	if options.RecoveryPolicy < RecoveryTruncateTail || options.RecoveryPolicy > RecoveryQuarantine {
+		return errors.New("invalid recovery policy")
+	}
+	if options.RecoverUntil != nil && !options.ReadOnly {
+		return errors.New("point-in-time recovery requires read-only mode")
+	}
+	if options.ScrubInterval < 0 || options.ScrubBytesPerSecond < 0 {
+		return errors.New("scrub interval and bandwidth must not be negative")
+	}
+	if options.ExpireCheckInterval < 0 {
+		return errors.New("expire check interval must not be negative")
+	}
 	return nil
 }
 
@@ -559,9 +1076,10 @@ func (db *DB) loadIndexFromDataFiles() error {
 	}
 
 	// 查看是否发生过 merge
+	// 时间点恢复时没有加载 Hint 文件，需要读取所有的数据文件
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := os.Stat(mergeFinFileName); err == nil && db.options.RecoverUntil == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -572,7 +1090,8 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
 		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
+		// 已经过期的数据和删除的数据一样处理
+		if typ == data.LogRecordDeleted || pos.IsExpired() {
 			oldPos, _ = db.index.Delete(key)
 			db.reclaimSize += int64(pos.Size)
 		} else {
@@ -586,6 +1105,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo uint64 = nonTransactionSeqNo
+	var commitSeq uint64
 
 	// 对文件进行遍历，处理文件中的记录
 	for i, fid := range db.fileIds {
@@ -602,24 +1122,53 @@ func (db *DB) loadIndexFromDataFiles() error {
 			dataFile = db.olderFiles[fileId]
 		}
 
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
 		var offset int64 = 0
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
-				if err == io.EOF {
+				if err == io.EOF && offset >= fileSize {
 					break
 				}
-				return err
+				if err != io.EOF && err != data.ErrInvalidCRC {
+					return err
+				}
+				// 记录不完整或者已经损坏，按照恢复策略处理
+				next, err := db.recoverDataFile(dataFile, offset, i == len(db.fileIds)-1)
+				if err != nil {
+					return err
+				}
+				if next < 0 {
+					break
+				}
+				offset = next
+				continue
 			}
 
+			// 时间点恢复时跳过在恢复目标之后提交的写入
+			if db.options.RecoverUntil != nil && !db.options.RecoverUntil.includes(logRecord) {
+				offset += size
+				continue
+			}
+			commitSeq = max(commitSeq, logRecord.CommitSeq)
+
 			// 构建内存索引并保存
-			logRecordPos := data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
+			logRecordPos := data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
 
 			// 解析 key，拿到事务的序列号
 			readKey, seqNo := parseLogRecordKey(logRecord.Key)
 			if seqNo == nonTransactionSeqNo {
 				// 非事务操作，直接更新内存索引
-				updateIndex(readKey, logRecord.Type, &logRecordPos)
+				if logRecord.Type == data.LogRecordRangeDeleted {
+					// 范围删除标记，删除范围内的所有 key
+					db.deleteIndexRange(readKey, logRecord.Value)
+					db.reclaimSize += size
+				} else {
+					updateIndex(readKey, logRecord.Type, &logRecordPos)
+				}
 			} else {
 				// 事务完成，对应的 seqNo 数据都是有效的，可以更新到内存索引中
 				if logRecord.Type == data.LogRecordTxnFinished {
@@ -647,9 +1196,77 @@ func (db *DB) loadIndexFromDataFiles() error {
 	}
 
 	db.seqNo = currentSeqNo
+	db.commitSeq = commitSeq
+	// 只读实例和从节点之后会继续读取到事务完成的记录
+	if db.options.ReadOnly || db.isReplica {
+		db.pendingTxnRecords = transactionRecords
+	} else {
+		// 没有事务完成记录的事务没有提交成功，其中的记录全部丢弃
+		for _, records := range transactionRecords {
+			db.recoveryReport.SkippedRecords += len(records)
+		}
+	}
 	return nil
 }
 
+// 将其他进程或主节点新写入的一条日志记录更新到内存索引中，和启动时从数据文件加载索引的处理一致
+// 事务中的记录暂存在 txnRecords 中，读取到事务完成的记录之后才更新
+// 在访问此方法前必须持有互斥锁
+func (db *DB) replayLogRecord(logRecord *data.LogRecord, pos *data.LogRecordPos,
+	txnRecords map[uint64][]*data.TransactionRecord) {
+	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		var oldPos *data.LogRecordPos
+		if typ == data.LogRecordDeleted {
+			oldPos, _ = db.index.Delete(key)
+			db.reclaimSize += int64(pos.Size)
+		} else {
+			oldPos = db.index.Put(key, pos)
+			if pos.Expire > 0 {
+				db.expireQueue.push(key, pos.Expire)
+			}
+		}
+		if oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+		db.markModified(key)
+	}
+
+	if logRecord.CommitSeq > db.commitSeq {
+		db.commitSeq = logRecord.CommitSeq
+	}
+
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo == nonTransactionSeqNo {
+		db.version++
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			for _, key := range db.deleteIndexRange(realKey, logRecord.Value) {
+				db.markModified(key)
+			}
+			db.reclaimSize += int64(pos.Size)
+		} else {
+			updateIndex(realKey, logRecord.Type, pos)
+		}
+		return
+	}
+
+	if logRecord.Type == data.LogRecordTxnFinished {
+		db.version++
+		for _, txnRecord := range txnRecords[seqNo] {
+			updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+		}
+		delete(txnRecords, seqNo)
+	} else {
+		logRecord.Key = realKey
+		txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
+			Record: logRecord,
+			Pos:    pos,
+		})
+	}
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+}
+
 // 将数据文件的 IO 类型设置为标准文件IO
 func (db *DB) resetIoType() error {
 	if db.activeFile == nil {
@@ -3,7 +3,11 @@ package bitcask_kv
 import (
 	"bitcask-kv/utils"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -218,8 +222,7 @@ func TestDB_Delete(t *testing.T) {
 
 	// 5.重启之后，再进行校验
 	if db.activeFile != nil {
-		// 待替代
-		_ = db.activeFile.Close()
+		_ = db.Close()
 	}
 	for _, of := range db.olderFiles {
 		if of != nil {
@@ -237,3 +240,262 @@ func TestDB_Delete(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, val1, val2)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.ttl 不合法
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 2.未过期之前可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	ttl1, err := db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, ttl1 > 59*time.Minute && ttl1 <= time.Hour)
+	ttl2, err := db.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl2)
+
+	// 3.过期之后 Get、TTL、ListKeys、Fold 都看不到
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.NotEqual(t, utils.GetTestKey(1), key)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// 4.重新 Put 之后清除过期时间
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	ttl3, err := db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl3)
+
+	// 5.重启之后过期时间依然有效
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ttl4, err := db2.TTL(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.True(t, ttl4 > 59*time.Minute && ttl4 <= time.Hour)
+}
+
+func TestDB_ConditionalWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.PutIfAbsent
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = db.PutIfAbsent(nil, []byte("v1"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 2.CompareAndSwap
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v2"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v1"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(2), nil, []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.CompareAndDelete
+	ok, err = db.CompareAndDelete(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndDelete(utils.GetTestKey(1), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 4.并发 PutIfAbsent 只有一个能成功
+	var succeed int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := db.PutIfAbsent(utils.GetTestKey(100), []byte(strconv.Itoa(i)))
+			assert.Nil(t, err)
+			if ok {
+				atomic.AddInt32(&succeed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeed)
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("tenant-a:1"), []byte("a1"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("tenant-a:2"), []byte("a2"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("tenant-b:1"), []byte("b1"))
+	assert.Nil(t, err)
+
+	// 1.参数校验
+	assert.Equal(t, ErrKeyIsEmpty, db.DeleteRange(nil, utils.GetTestKey(1)))
+	assert.Equal(t, ErrInvalidRange, db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(10)))
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+
+	// 2.删除 [10, 20)
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+
+	// 3.按前缀删除
+	err = db.DeletePrefix([]byte("tenant-a:"))
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("tenant-a:1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get([]byte("tenant-b:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b1"), val)
+	assert.Equal(t, 91, len(db.ListKeys()))
+
+	// 4.范围删除之后重新写入的数据不受影响
+	err = db.Put(utils.GetTestKey(15), []byte("new"))
+	assert.Nil(t, err)
+
+	// 5.重启之后重放范围删除标记
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get([]byte("tenant-a:2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	assert.Equal(t, 92, len(db2.ListKeys()))
+}
+
+func Test_prefixUpperBound(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixUpperBound([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixUpperBound([]byte{'a', 0xff}))
+	assert.Nil(t, prefixUpperBound([]byte{0xff, 0xff}))
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 写入多个数据文件
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	err = db.Delete(utils.GetTestKey(5))
+	assert.Nil(t, err)
+
+	keys := [][]byte{
+		utils.GetTestKey(999),
+		utils.GetTestKey(0),
+		utils.GetTestKey(1),
+		utils.GetTestKey(2),
+		utils.GetTestKey(5),
+		nil,
+		[]byte("unknown key"),
+		utils.GetTestKey(500),
+		utils.GetTestKey(1),
+	}
+	vals, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(vals))
+	assert.Equal(t, len(keys), len(errs))
+
+	assert.Nil(t, errs[0])
+	assert.Equal(t, values[999], vals[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, values[0], vals[1])
+	assert.Nil(t, errs[2])
+	assert.Equal(t, values[1], vals[2])
+	assert.Nil(t, errs[3])
+	assert.Equal(t, values[2], vals[3])
+	assert.Equal(t, ErrKeyNotFound, errs[4])
+	assert.Equal(t, ErrKeyIsEmpty, errs[5])
+	assert.Equal(t, ErrKeyNotFound, errs[6])
+	assert.Nil(t, errs[7])
+	assert.Equal(t, values[500], vals[7])
+	assert.Nil(t, errs[8])
+	assert.Equal(t, values[1], vals[8])
+}
@@ -9,8 +9,39 @@ var (
 	ErrDataFileNotFound       = errors.New("data file is not found")
 	ErrDataDirectoryCorrupted = errors.New("the database maybe corrupted")
 	ErrExceedMaxBatchNum      = errors.New("exceed the max batch Num")
+	ErrExceedMaxBatchBytes    = errors.New("exceed the max batch bytes")
+	ErrNoSavepoint            = errors.New("no savepoint has been set")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRationUnreached   = errors.New("merge ratio is unreached")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough space for merge ratio")
+	ErrInvalidTTL             = errors.New("the ttl must be greater than 0")
+	ErrInvalidRange           = errors.New("the range end must be greater than start")
+	ErrBatchConditionFailed   = errors.New("the write batch precondition is not satisfied")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, keys have been modified by others")
+	ErrTxnFinished            = errors.New("the transaction has been committed or rolled back")
+	ErrTxnReadOnly            = errors.New("cannot write in a read-only transaction")
+	ErrLockWaitTimeout        = errors.New("timeout waiting for the key lock")
+	ErrTxnDeadlock            = errors.New("deadlock detected, the transaction is chosen as the victim")
+	ErrWatchSeqCompacted      = errors.New("the watch sequence number has been compacted by merge")
+	ErrReplicaReadOnly        = errors.New("cannot write to a replica, promote it first")
+	ErrReplicationResync      = errors.New("the replica has diverged from the primary, resync is required")
+	ErrInvalidWatchSeq        = errors.New("the watch sequence number is beyond the end of the data files")
+	ErrDatabaseReadOnly       = errors.New("cannot write to a database opened in read-only mode")
+	ErrNotReadOnly            = errors.New("refresh is only supported in read-only mode")
+	ErrCheckpointDirExists    = errors.New("the checkpoint directory already exists")
+	ErrBackupNotSibling       = errors.New("the incremental backup must be in the same directory as the previous backup")
+	ErrBackupCorrupted        = errors.New("the backup is corrupted")
+	ErrRestoreDirExists       = errors.New("the restore target directory already exists")
+	ErrRefreshRecovering      = errors.New("cannot refresh a database opened for point-in-time recovery")
+	ErrDataFileCorrupted      = errors.New("the data file is corrupted")
+	ErrRepairDirExists        = errors.New("the repair target directory already exists")
+	ErrScrubIsProgress        = errors.New("scrub is in progress, try again later")
+	ErrInvalidImportData      = errors.New("the import data is invalid")
+	ErrUnknownExportFormat    = errors.New("unknown export format")
+	ErrBulkLoadDirExists      = errors.New("the bulk load target directory already exists")
+	ErrBulkLoadKeyNotSorted   = errors.New("bulk load keys must be added in strictly increasing order")
+	ErrBulkLoadFinished       = errors.New("the bulk loader has been finished or aborted")
+	ErrBulkLoadBPTree         = errors.New("bulk load does not support the B+ tree index")
 )
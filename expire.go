@@ -0,0 +1,137 @@
+package bitcask_kv
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// 每次后台检查最多清理的过期 key 数量，避免长时间持有锁
+const maxExpireKeysPerCheck = 1000
+
+// Options.ExpireCheckInterval 为 0 时后台清理过期 key 的间隔
+const defaultExpireCheckInterval = time.Second
+
+// 过期队列中的元素
+type expireItem struct {
+	key    []byte
+	expire int64
+}
+
+// 按照过期时间排序的小顶堆
+type expireHeap []*expireItem
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].expire < h[j].expire }
+func (h expireHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expireHeap) Push(x any) {
+	*h = append(*h, x.(*expireItem))
+}
+
+func (h *expireHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// 过期队列，记录所有设置了过期时间的 key
+// 队列中可能存在已经被覆盖或删除的 key，出队时需要和索引进行比对
+type expireQueue struct {
+	mtx   *sync.Mutex
+	items expireHeap
+}
+
+func newExpireQueue() *expireQueue {
+	return &expireQueue{mtx: new(sync.Mutex)}
+}
+
+// 添加一个带有过期时间的 key
+func (eq *expireQueue) push(key []byte, expire int64) {
+	eq.mtx.Lock()
+	defer eq.mtx.Unlock()
+	heap.Push(&eq.items, &expireItem{key: key, expire: expire})
+}
+
+// 取出一个在 now 之前已经过期的 key，没有则返回 nil
+func (eq *expireQueue) popExpired(now int64) *expireItem {
+	eq.mtx.Lock()
+	defer eq.mtx.Unlock()
+	if len(eq.items) == 0 || eq.items[0].expire > now {
+		return nil
+	}
+	return heap.Pop(&eq.items).(*expireItem)
+}
+
+func (eq *expireQueue) len() int {
+	eq.mtx.Lock()
+	defer eq.mtx.Unlock()
+	return len(eq.items)
+}
+
+// 将索引中带有过期时间的 key 加入过期队列，在启动加载索引之后调用
+func (db *DB) loadExpireQueue() {
+	it := db.index.Iterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if pos := it.Value(); pos.Expire > 0 {
+			db.expireQueue.push(it.Key(), pos.Expire)
+		}
+	}
+}
+
+// 后台定期清理过期的 key
+func (db *DB) startExpireCheck() error {
+	interval := db.options.ExpireCheckInterval
+	if interval == 0 {
+		interval = defaultExpireCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.deleteExpiredKeys(); err != nil {
+				return err
+			}
+		case <-db.closeChan:
+			return nil
+		}
+	}
+}
+
+// 为已经过期的 key 写入删除记录，并从内存索引中移除
+func (db *DB) deleteExpiredKeys() error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	// 数据库已经关闭则直接返回
+	select {
+	case <-db.closeChan:
+		return nil
+	default:
+	}
+
+	now := time.Now().UnixNano()
+	for i := 0; i < maxExpireKeysPerCheck; i++ {
+		item := db.expireQueue.popExpired(now)
+		if item == nil {
+			break
+		}
+
+		// key 已经被删除或者被重新写入，忽略
+		oldPos := db.index.Get(item.key)
+		if oldPos == nil || oldPos.Expire != item.expire {
+			continue
+		}
+
+		if err := db.delete(item.key); err != nil {
+			return err
+		}
+		db.expiredKeyNum++
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExpireCheck(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-expire-check")
+	opts.DirPath = dir
+	opts.ExpireCheckInterval = 50 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(24), 100*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 200; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(24), time.Hour)
+		assert.Nil(t, err)
+	}
+	// 被覆盖为永不过期的 key 不会被清理
+	err = db.Put(utils.GetTestKey(0), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	stat := db.Stat()
+	assert.Equal(t, uint(200), stat.KeyNum)
+	assert.Equal(t, uint(200), stat.ExpireKeyNum)
+
+	time.Sleep(300 * time.Millisecond)
+	stat = db.Stat()
+	assert.Equal(t, uint(101), stat.KeyNum)
+	assert.Equal(t, uint(100), stat.ExpireKeyNum)
+	assert.Equal(t, uint64(99), stat.ExpiredKeyNum)
+	assert.True(t, stat.ReclaimableSize > 0)
+
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 重启之后过期队列重新加载
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	stat = db2.Stat()
+	assert.Equal(t, uint(101), stat.KeyNum)
+	assert.Equal(t, uint(100), stat.ExpireKeyNum)
+}
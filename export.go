@@ -0,0 +1,234 @@
+package bitcask_kv
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// 导出时每导出多少条数据报告一次进度
+	exportProgressInterval = 10000
+	// key 和 value 使用 base64 编码时 encoding 字段的值
+	exportEncodingBase64 = "base64"
+)
+
+// CSV 格式的表头
+var exportCSVHeader = []string{"key", "value", "encoding", "expire"}
+
+// 导出的一条数据，encoding 为 base64 时 key 和 value 都使用 base64 编码，expire 为过期时间的 UnixNano 时间戳
+type exportRecord struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+	Expire   int64  `json:"expire,omitempty"`
+}
+
+// Export 将数据库中所有有效的 key/value 以 format 格式写入到 w 中，返回导出的数据条数
+// 在快照上遍历，导出期间的写入不会影响导出的结果。不是合法 UTF-8 的 key 或 value 使用 base64 编码
+func (db *DB) Export(w io.Writer, format ExportFormat, opts ExportOptions) (int, error) {
+	if format != ExportJSONLines && format != ExportCSV {
+		return 0, ErrUnknownExportFormat
+	}
+
+	snapshot := db.Snapshot()
+	defer snapshot.Release()
+	it := snapshot.NewIterator(IteratorOptions{Prefix: opts.Prefix})
+	defer it.Close()
+
+	bw := bufio.NewWriter(w)
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	if format == ExportCSV {
+		csvWriter = csv.NewWriter(bw)
+		if err := csvWriter.Write(exportCSVHeader); err != nil {
+			return 0, err
+		}
+	} else {
+		encoder = json.NewEncoder(bw)
+	}
+
+	var count int
+	for ; it.Valid(); it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return count, err
+		}
+		record := newExportRecord(it.Key(), value, it.indexIter.Value().Expire, opts.Base64)
+		if csvWriter != nil {
+			var expire string
+			if record.Expire > 0 {
+				expire = strconv.FormatInt(record.Expire, 10)
+			}
+			err = csvWriter.Write([]string{record.Key, record.Value, record.Encoding, expire})
+		} else {
+			err = encoder.Encode(record)
+		}
+		if err != nil {
+			return count, err
+		}
+
+		count++
+		if opts.Progress != nil && count%exportProgressInterval == 0 {
+			opts.Progress(count)
+		}
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return count, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+	return count, nil
+}
+
+func newExportRecord(key, value []byte, expire int64, forceBase64 bool) *exportRecord {
+	if forceBase64 || !utf8.Valid(key) || !utf8.Valid(value) {
+		return &exportRecord{
+			Key:      base64.StdEncoding.EncodeToString(key),
+			Value:    base64.StdEncoding.EncodeToString(value),
+			Encoding: exportEncodingBase64,
+			Expire:   expire,
+		}
+	}
+	return &exportRecord{Key: string(key), Value: string(value), Expire: expire}
+}
+
+// Import 读取 Export 导出的 format 格式的数据并写入到数据库中，返回写入的 key 数量
+// 使用 WriteBatch 批量写入，每个批次最多 opts.BatchNum 个 key，同一批次中重复的 key 只写入最后一次的值并计数一次，
+// 已经过期的数据会被跳过。
+// 数据格式错误时返回 ErrInvalidImportData，之前的批次已经写入
+func (db *DB) Import(r io.Reader, format ExportFormat, opts ImportOptions) (int, error) {
+	if format != ExportJSONLines && format != ExportCSV {
+		return 0, ErrUnknownExportFormat
+	}
+	if opts.BatchNum == 0 {
+		opts.BatchNum = DefaultImportOptions.BatchNum
+	}
+
+	wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: opts.BatchNum, SyncWrites: opts.SyncWrites})
+	var count int
+	commit := func() error {
+		pending := wb.Len()
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		count += pending
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	err := readExportRecords(r, format, func(record *exportRecord) error {
+		key, value, err := record.decode()
+		if err != nil {
+			return err
+		}
+		if record.Expire > 0 && record.Expire <= now {
+			return nil
+		}
+		if err := wb.putWithExpire(key, value, record.Expire); err != nil {
+			return err
+		}
+		if uint(wb.Len()) >= opts.BatchNum {
+			return commit()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if wb.Len() > 0 {
+		if err := commit(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (record *exportRecord) decode() ([]byte, []byte, error) {
+	switch record.Encoding {
+	case "":
+		return []byte(record.Key), []byte(record.Value), nil
+	case exportEncodingBase64:
+		key, err := base64.StdEncoding.DecodeString(record.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+		}
+		value, err := base64.StdEncoding.DecodeString(record.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+		}
+		return key, value, nil
+	}
+	return nil, nil, fmt.Errorf("%w: unknown encoding %q", ErrInvalidImportData, record.Encoding)
+}
+
+// 依次读取导出的每一条数据
+func readExportRecords(r io.Reader, format ExportFormat, fn func(record *exportRecord) error) error {
+	if format == ExportJSONLines {
+		decoder := json.NewDecoder(bufio.NewReader(r))
+		decoder.DisallowUnknownFields()
+		for line := 1; ; line++ {
+			record := &exportRecord{}
+			if err := decoder.Decode(record); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("%w: record %d: %v", ErrInvalidImportData, line, err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	csvReader := csv.NewReader(bufio.NewReader(r))
+	csvReader.FieldsPerRecord = len(exportCSVHeader)
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	for i, name := range exportCSVHeader {
+		if header[i] != name {
+			return fmt.Errorf("%w: unexpected csv header %v", ErrInvalidImportData, header)
+		}
+	}
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+		}
+		record := &exportRecord{Key: row[0], Value: row[1], Encoding: row[2]}
+		if row[3] != "" {
+			if record.Expire, err = strconv.ParseInt(row[3], 10, 64); err != nil {
+				line, _ := csvReader.FieldPos(3)
+				return fmt.Errorf("%w: line %d: invalid expire %q", ErrInvalidImportData, line, row[3])
+			}
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
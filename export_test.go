@@ -0,0 +1,140 @@
+package bitcask_kv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportJSONLines, ExportCSV} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-export")
+		opts.DirPath = dir
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		defer destroyDB(db)
+
+		assert.Nil(t, db.Put([]byte("user:1"), []byte("alice, \"quoted\"\nnew line")))
+		assert.Nil(t, db.Put([]byte("user:2"), []byte{0xff, 0x00, 0x01}))
+		assert.Nil(t, db.PutWithTTL([]byte("user:3"), []byte("ttl"), time.Hour))
+		assert.Nil(t, db.PutWithTTL([]byte("user:4"), []byte("expired"), time.Millisecond))
+		assert.Nil(t, db.Put([]byte("order:1"), []byte("order")))
+		time.Sleep(5 * time.Millisecond)
+
+		var buf bytes.Buffer
+		var progress int
+		exportOpts := DefaultExportOptions
+		exportOpts.Prefix = []byte("user:")
+		exportOpts.Progress = func(count int) {
+			progress = count
+		}
+		count, err := db.Export(&buf, format, exportOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, 3, progress)
+
+		opts2 := DefaultOptions
+		dir2, _ := os.MkdirTemp("", "bitcask-go-import")
+		opts2.DirPath = dir2
+		db2, err := Open(opts2)
+		assert.Nil(t, err)
+		defer destroyDB(db2)
+
+		importOpts := DefaultImportOptions
+		importOpts.BatchNum = 2
+		var commits int
+		importOpts.Progress = func(count int) {
+			commits++
+		}
+		count, err = db2.Import(&buf, format, importOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, 2, commits)
+
+		assert.Equal(t, 3, len(db2.ListKeys()))
+		val, err := db2.Get([]byte("user:1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("alice, \"quoted\"\nnew line"), val)
+		val, err = db2.Get([]byte("user:2"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{0xff, 0x00, 0x01}, val)
+		ttl, err := db2.TTL([]byte("user:3"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 50*time.Minute && ttl <= time.Hour)
+	}
+}
+
+func TestDB_ExportBase64(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-export-base64")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	var buf bytes.Buffer
+	exportOpts := DefaultExportOptions
+	exportOpts.Base64 = true
+	_, err = db.Export(&buf, ExportJSONLines, exportOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"key":"YQ==","value":"MQ==","encoding":"base64"}`+"\n", buf.String())
+
+	_, err = db.Export(&buf, 9, exportOpts)
+	assert.Equal(t, ErrUnknownExportFormat, err)
+}
+
+func TestDB_ImportInvalidData(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-import-invalid")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.Import(strings.NewReader(`{"key":"a","value":"1"}`+"\n"+`{"key":`), ExportJSONLines, DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrInvalidImportData))
+	_, err = db.Import(strings.NewReader("key,value\na,1\n"), ExportCSV, DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrInvalidImportData))
+	_, err = db.Import(strings.NewReader(`{"key":"a","value":"!","encoding":"base64"}`), ExportJSONLines, DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrInvalidImportData))
+}
+
+func TestDB_ImportDuplicateKeys(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-import-duplicate")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	input := strings.Join([]string{
+		`{"key":"a","value":"1"}`,
+		`{"key":"a","value":"2"}`,
+		`{"key":"b","value":"1"}`,
+		`{"key":"a","value":"3"}`,
+		`{"key":"c","value":"1"}`,
+	}, "\n")
+	importOpts := DefaultImportOptions
+	importOpts.BatchNum = 2
+	var progress []int
+	importOpts.Progress = func(count int) {
+		progress = append(progress, count)
+	}
+
+	// 同一批次中重复的 key 只计数一次，批次按照 key 的数量划分
+	count, err := db.Import(strings.NewReader(input), ExportJSONLines, importOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, []int{2, 4}, progress)
+	assert.Equal(t, 3, len(db.ListKeys()))
+	val, err := db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+}
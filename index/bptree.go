@@ -3,11 +3,13 @@ package index
 import (
 	"bitcask-kv/data"
 	"go.etcd.io/bbolt"
+	"io"
 	"path/filepath"
+	"time"
 )
 
 // 索引文件名称
-const bptreeIndexFileName = "bptree-index"
+const BPTreeIndexFileName = "bptree-index"
 
 // Bucket名称
 var indexBucketName = []byte("bitcask-index")
@@ -24,7 +26,7 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	// 可自定义配置项
 	opts.NoSync = !syncWrites // 是否不进行立即持久化
 	// 打开索引文件 后续将索引持久化到磁盘中
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -107,6 +109,61 @@ func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
+// CopyTo 将索引当前的一致性快照拷贝到 dirPath 目录下的索引文件中
+func (bpt *BPlusTree) CopyTo(dirPath string) error {
+	return bpt.tree.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(filepath.Join(dirPath, BPTreeIndexFileName), 0644)
+	})
+}
+
+// BPTreeSnapshot B+ 树索引在某一时刻的一致性快照
+// 快照持有一个只读事务，关闭之前索引文件扩容时需要等待，使用完之后需要尽快关闭
+type BPTreeSnapshot struct {
+	tx *bbolt.Tx
+}
+
+// Snapshot 获取索引当前的一致性快照
+func (bpt *BPlusTree) Snapshot() (*BPTreeSnapshot, error) {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &BPTreeSnapshot{tx: tx}, nil
+}
+
+// WriteTo 将快照对应的索引文件写入到 w 中
+func (s *BPTreeSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return s.tx.WriteTo(w)
+}
+
+// Close 关闭快照
+func (s *BPTreeSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+// ReadBPTreeIndexFile 以只读方式打开索引文件，按 key 的顺序对每条索引调用 fn
+// 索引文件正在被数据库使用时，等待 timeout 之后返回错误
+func ReadBPTreeIndexFile(fileName string, timeout time.Duration, fn func(key []byte, pos *data.LogRecordPos) error) error {
+	opts := *bbolt.DefaultOptions
+	opts.ReadOnly = true
+	opts.Timeout = timeout
+	tree, err := bbolt.Open(fileName, 0644, &opts)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+
+	return tree.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			return fn(k, data.DecodeLogRecordPos(v))
+		})
+	})
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
@@ -35,7 +35,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -54,9 +56,21 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
+// Clone 以写时复制的方式克隆索引，克隆之后两者互不影响
+func (bt *BTree) Clone() *BTree {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
 func (bt *BTree) Close() error {
 	return nil
 }
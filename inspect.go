@@ -0,0 +1,214 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/index"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 检查 B+ 树索引文件时等待数据库释放索引文件的时间
+const inspectBPTreeTimeout = time.Second
+
+// InspectRecord 检查数据目录时从文件中解析出的一条记录
+type InspectRecord struct {
+	File       string
+	Offset     int64
+	Size       int64
+	Type       string // 记录的类型，数据文件中为 normal、deleted、txn-finished、range-deleted，其他文件为文件的类型
+	SeqNo      uint64 // 事务序列号，非事务写入为 0
+	Key        []byte // 去掉事务序列号之后实际的 key
+	Value      []byte
+	Expire     int64
+	CommitSeq  uint64
+	CommitTime int64
+	Pos        *data.LogRecordPos // Hint 文件和 B+ 树索引文件中的位置索引
+	Live       bool               // 是否为 key 当前有效的版本，其他文件中可以解析的记录都是有效的
+	Corrupted  string             // 无法解析的原因，不为空时只有 File、Offset 和 Size 有效
+}
+
+// InspectSummary 检查数据目录时一个文件的统计信息
+type InspectSummary struct {
+	File           string `json:"file"`
+	Records        int    `json:"records"`         // 可以解析的记录数量
+	LiveRecords    int    `json:"live_records"`    // 有效的记录数量
+	LiveBytes      int64  `json:"live_bytes"`      // 有效的记录占用的字节数
+	DeadBytes      int64  `json:"dead_bytes"`      // 被覆盖、删除或者过期的记录以及没有提交的事务占用的字节数，merge 之后可以回收
+	CorruptedBytes int64  `json:"corrupted_bytes"` // 无法解析的字节数
+}
+
+// Inspect 解析数据目录中的文件，对每条记录调用 fn，返回每个文件的统计信息
+// names 为需要解析的文件名称，为空表示数据目录中所有的数据文件、Hint 文件、merge 完成标识文件、事务序列号文件和 B+ 树索引文件。
+// 以只读方式打开数据库构建索引，以判断数据文件中的记录是否为 key 当前有效的版本，可以在数据库运行时使用
+func Inspect(dirPath string, names []string, fn func(record *InspectRecord) error) ([]*InspectSummary, error) {
+	if len(names) == 0 {
+		var err error
+		if names, err = inspectFileNames(dirPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		if !isInspectFileName(name) {
+			return nil, fmt.Errorf("%s: unknown file type", name)
+		}
+	}
+
+	// 只用于判断记录是否有效，跳过损坏的数据
+	opts := DefaultOptions
+	opts.DirPath = dirPath
+	opts.ReadOnly = true
+	opts.RecoveryPolicy = RecoverySkipCorrupted
+	db, err := Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var summaries []*InspectSummary
+	for _, name := range names {
+		summary := &InspectSummary{File: name}
+		if name == index.BPTreeIndexFileName {
+			err = inspectBPTreeIndexFile(dirPath, summary, fn)
+		} else {
+			err = db.inspectLogFile(name, summary, fn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// 数据目录中可以解析的文件，数据文件按照 id 排序
+func inspectFileNames(dirPath string) ([]string, error) {
+	files, err := listDataFiles(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file.fileName))
+	}
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName, data.SeqNoFileName, index.BPTreeIndexFileName} {
+		if _, err := os.Stat(filepath.Join(dirPath, name)); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func isInspectFileName(name string) bool {
+	if _, ok := parseDataFileName(name); ok {
+		return true
+	}
+	switch name {
+	case data.HintFileName, data.MergeFinishedFileName, data.SeqNoFileName, index.BPTreeIndexFileName:
+		return true
+	}
+	return false
+}
+
+// 解析由日志记录组成的文件
+func (db *DB) inspectLogFile(name string, summary *InspectSummary, fn func(record *InspectRecord) error) error {
+	fid, isDataFile := parseDataFileName(name)
+	var logFile *data.DataFile
+	var err error
+	if isDataFile {
+		logFile, err = data.OpenDataFileWithName(filepath.Join(db.options.DirPath, name), fid, fio.StandardIO)
+	} else {
+		var ok bool
+		logFile, ok, err = openLogFile(db.options.DirPath, name, func(dirPath string) (*data.DataFile, error) {
+			return data.OpenDataFileWithName(filepath.Join(dirPath, name), 0, fio.StandardIO)
+		})
+		if err == nil && !ok {
+			return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	var fnErr error
+	onCorrupted := func(offset int64, size int64, reason string) {
+		summary.CorruptedBytes += size
+		if fnErr == nil {
+			fnErr = fn(&InspectRecord{File: name, Offset: offset, Size: size, Corrupted: reason})
+		}
+	}
+	err = scanLogFile(logFile, func(logRecord *data.LogRecord, offset int64, size int64) error {
+		if fnErr != nil {
+			return fnErr
+		}
+		record := &InspectRecord{
+			File:       name,
+			Offset:     offset,
+			Size:       size,
+			Type:       name,
+			Key:        logRecord.Key,
+			Value:      logRecord.Value,
+			Expire:     logRecord.Expire,
+			CommitSeq:  logRecord.CommitSeq,
+			CommitTime: logRecord.CommitTime,
+			Live:       true,
+		}
+		if isDataFile {
+			record.Type = logRecordTypeName(logRecord.Type)
+			record.Key, record.SeqNo = parseLogRecordKey(logRecord.Key)
+			pos := db.index.Get(record.Key)
+			record.Live = logRecord.Type == data.LogRecordNormal && pos != nil && pos.Fid == fid && pos.Offset == offset
+		} else if name == data.HintFileName {
+			record.Pos = data.DecodeLogRecordPos(logRecord.Value)
+		}
+
+		summary.Records++
+		if record.Live {
+			summary.LiveRecords++
+			summary.LiveBytes += size
+		} else {
+			summary.DeadBytes += size
+		}
+		return fn(record)
+	}, onCorrupted)
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// 解析 B+ 树索引文件，每条索引的大小为编码之后 key 和位置索引的长度
+func inspectBPTreeIndexFile(dirPath string, summary *InspectSummary, fn func(record *InspectRecord) error) error {
+	fileName := filepath.Join(dirPath, index.BPTreeIndexFileName)
+	return index.ReadBPTreeIndexFile(fileName, inspectBPTreeTimeout, func(key []byte, pos *data.LogRecordPos) error {
+		size := int64(len(key) + len(data.EncodeLogRecordPos(pos)))
+		summary.Records++
+		summary.LiveRecords++
+		summary.LiveBytes += size
+		return fn(&InspectRecord{
+			File: index.BPTreeIndexFileName,
+			Size: size,
+			Type: index.BPTreeIndexFileName,
+			Key:  key,
+			Pos:  pos,
+			Live: true,
+		})
+	})
+}
+
+func logRecordTypeName(typ data.LogRecordType) string {
+	switch typ {
+	case data.LogRecordNormal:
+		return "normal"
+	case data.LogRecordDeleted:
+		return "deleted"
+	case data.LogRecordTxnFinished:
+		return "txn-finished"
+	case data.LogRecordRangeDeleted:
+		return "range-deleted"
+	}
+	return fmt.Sprintf("unknown(%d)", typ)
+}
@@ -0,0 +1,98 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/index"
+	"bitcask-kv/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-inspect")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("a"), []byte("2")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("3")))
+	assert.Nil(t, db.Delete([]byte("b")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("c"), []byte("4")))
+	assert.Nil(t, wb.Commit())
+
+	// 数据库运行时也可以解析
+	var records []*InspectRecord
+	summaries, err := Inspect(dir, nil, func(record *InspectRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(summaries))
+	assert.Equal(t, 6, len(records))
+
+	var liveKeys []string
+	for _, record := range records {
+		if record.Live {
+			liveKeys = append(liveKeys, string(record.Key))
+		}
+	}
+	assert.Equal(t, []string{"a", "c"}, liveKeys)
+	assert.Equal(t, "deleted", records[3].Type)
+	assert.Equal(t, "normal", records[4].Type)
+	assert.True(t, records[4].SeqNo > nonTransactionSeqNo)
+	assert.Equal(t, "txn-finished", records[5].Type)
+	assert.Equal(t, records[4].SeqNo, records[5].SeqNo)
+
+	summary := summaries[0]
+	assert.Equal(t, "000000000.data", summary.File)
+	assert.Equal(t, 6, summary.Records)
+	assert.Equal(t, 2, summary.LiveRecords)
+	assert.Equal(t, records[1].Size+records[4].Size, summary.LiveBytes)
+	assert.Equal(t, records[5].Offset+records[5].Size-summary.LiveBytes, summary.DeadBytes)
+
+	// 末尾不完整的记录
+	assert.Nil(t, db.Close())
+	appendToDataFile(t, dir, 0, []byte("torn"))
+	summaries, err = Inspect(dir, []string{"000000000.data", data.SeqNoFileName}, func(record *InspectRecord) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(summaries))
+	assert.Equal(t, int64(4), summaries[0].CorruptedBytes)
+	assert.Equal(t, data.SeqNoFileName, summaries[1].File)
+	assert.Equal(t, 1, summaries[1].LiveRecords)
+
+	_, err = Inspect(dir, []string{"unknown"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestInspect_BPTreeIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-inspect-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.Close())
+
+	var keys int
+	summaries, err := Inspect(dir, []string{index.BPTreeIndexFileName}, func(record *InspectRecord) error {
+		assert.NotNil(t, record.Pos)
+		keys++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, keys)
+	assert.Equal(t, 10, summaries[0].LiveRecords)
+}
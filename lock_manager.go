@@ -0,0 +1,95 @@
+package bitcask_kv
+
+import (
+	"sync"
+	"time"
+)
+
+// 悲观事务持有的 key 锁
+type keyLock struct {
+	owner    uint64        // 持有锁的事务 id
+	released chan struct{} // 锁释放时关闭，用于唤醒等待者
+}
+
+// 悲观事务的锁管理器
+// 每个 key 同一时刻只能被一个事务持有，等待关系记录在 wait-for 图中用于死锁检测
+type lockManager struct {
+	mtx     *sync.Mutex
+	locks   map[string]*keyLock
+	held    map[uint64][]string // 事务 id -> 持有的 key
+	waitFor map[uint64]uint64   // 事务 id -> 正在等待的事务 id
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		mtx:     new(sync.Mutex),
+		locks:   make(map[string]*keyLock),
+		held:    make(map[uint64][]string),
+		waitFor: make(map[uint64]uint64),
+	}
+}
+
+// 为事务获取 key 的锁，等待超过 timeout 返回 ErrLockWaitTimeout
+// 如果等待会形成环，当前事务作为牺牲者返回 ErrTxnDeadlock
+func (lm *lockManager) lock(txnId uint64, key []byte, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		lm.mtx.Lock()
+		kl := lm.locks[string(key)]
+		if kl == nil {
+			lm.locks[string(key)] = &keyLock{owner: txnId, released: make(chan struct{})}
+			lm.held[txnId] = append(lm.held[txnId], string(key))
+			lm.mtx.Unlock()
+			return nil
+		}
+		// 可重入
+		if kl.owner == txnId {
+			lm.mtx.Unlock()
+			return nil
+		}
+		if lm.wouldDeadlock(txnId, kl.owner) {
+			lm.mtx.Unlock()
+			return ErrTxnDeadlock
+		}
+		lm.waitFor[txnId] = kl.owner
+		lm.mtx.Unlock()
+
+		select {
+		case <-kl.released:
+			lm.mtx.Lock()
+			delete(lm.waitFor, txnId)
+			lm.mtx.Unlock()
+		case <-timer.C:
+			lm.mtx.Lock()
+			delete(lm.waitFor, txnId)
+			lm.mtx.Unlock()
+			return ErrLockWaitTimeout
+		}
+	}
+}
+
+// 沿着 wait-for 图查找，如果 owner 最终在等待 txnId，说明会形成环
+// 在访问此方法前必须持有锁管理器的互斥锁
+func (lm *lockManager) wouldDeadlock(txnId, owner uint64) bool {
+	for next, ok := owner, true; ok; next, ok = lm.waitFor[next] {
+		if next == txnId {
+			return true
+		}
+	}
+	return false
+}
+
+// 释放事务持有的所有锁
+func (lm *lockManager) unlockAll(txnId uint64) {
+	lm.mtx.Lock()
+	defer lm.mtx.Unlock()
+	for _, key := range lm.held[txnId] {
+		if kl := lm.locks[key]; kl != nil && kl.owner == txnId {
+			close(kl.released)
+			delete(lm.locks, key)
+		}
+	}
+	delete(lm.held, txnId)
+}
@@ -11,8 +11,9 @@ import (
 )
 
 const (
-	mergeDirName     = "-merge"
-	mergeFinishedKey = "merge.finished"
+	mergeDirName      = "-merge"
+	mergeFinishedKey  = "merge.finished"
+	mergeCommitSeqKey = "merge.commit.seq"
 )
 
 // Merge 清理无效的数据，生成 Hint 文件
@@ -25,6 +26,12 @@ func (db *DB) Merge() error {
 
 	db.mtx.Lock()
 
+	// 只读实例不能修改数据目录，从节点的数据文件需要和主节点保持一致
+	if err := db.checkWritable(); err != nil {
+		db.mtx.Unlock()
+		return err
+	}
+
 	// 如果 merge 正在进行中，则直接返回
 	if db.isMerging {
 		db.mtx.Unlock()
@@ -74,6 +81,8 @@ func (db *DB) Merge() error {
 	}
 	// 记录最近没有参与 merge 文件的 id
 	nonMergeFileId := db.activeFile.FileId
+	// 参与 merge 的文件中的记录都不会超过当前的提交序列号
+	commitSeq := db.commitSeq
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
@@ -103,16 +112,23 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.noBackgroundTasks = true
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 
 	// 打开一个 hint 文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
@@ -128,8 +144,9 @@ func (db *DB) Merge() error {
 			// 解析拿到实际的 key
 			readKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(readKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
+			// 和内存中的索引位置进行比较，如果有效且未过期则重写
+			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired() {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithReq(readKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
@@ -161,13 +178,7 @@ func (db *DB) Merge() error {
 		return err
 	}
 
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
-	}
-
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
+	if err := mergeFinishedFile.Write(encodeMergeFinished(nonMergeFileId, commitSeq)); err != nil {
 		return err
 	}
 	if err := mergeFinishedFile.Sync(); err != nil {
@@ -176,9 +187,28 @@ func (db *DB) Merge() error {
 	return nil
 }
 
+// 编码 merge 完成标识文件的内容，包括未参与 merge 的最小文件 id 和 merge 重写的记录中最大的提交序列号
+// 打开数据库时没有读取 merge 重写的文件，据此恢复提交序列号
+func encodeMergeFinished(nonMergeFileId uint32, commitSeq uint64) []byte {
+	mergeFinRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(mergeFinishedKey),
+		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+	})
+	commitSeqRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(mergeCommitSeqKey),
+		Value: []byte(strconv.FormatUint(commitSeq, 10)),
+	})
+	return append(mergeFinRecord, commitSeqRecord...)
+}
+
 func (db *DB) getMergePath() string {
-	dir := filepath.Dir(filepath.Clean(db.options.DirPath))
-	base := filepath.Base(db.options.DirPath)
+	return mergeDirPath(db.options.DirPath)
+}
+
+// 数据目录对应的 merge 目录
+func mergeDirPath(dirPath string) string {
+	dir := filepath.Dir(filepath.Clean(dirPath))
+	base := filepath.Base(dirPath)
 	return filepath.Join(dir, base+mergeDirName)
 }
 
@@ -224,11 +254,17 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
-	// 删除旧的数据文件
+	// 删除旧的数据文件，配置了归档目录时移动到归档目录中
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
 		if _, err := os.Stat(fileName); err == nil {
+			if db.options.MergeArchiveDir != "" {
+				if err := db.archiveDataFile(fileName, nonMergeFileId); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := os.Remove(fileName); err != nil {
 				return err
 			}
@@ -246,6 +282,43 @@ func (db *DB) loadMergeFiles() error {
 	return nil
 }
 
+// 加载最近一次 merge 重写的数据文件范围，id 小于它的数据文件中只保留了有效的数据
+func (db *DB) loadMergeBoundary() error {
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); err != nil {
+		return nil
+	}
+	fid, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	db.mergeBoundary = fid
+	return nil
+}
+
+// 读取 merge 完成标识文件中保存的提交序列号，之前版本写入的文件中没有保存时返回 false
+func getMergeCommitSeq(dirPath string) (uint64, bool) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	if err != nil {
+		return 0, false
+	}
+	defer mergeFinishedFile.Close()
+
+	_, size, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, false
+	}
+	record, _, err := mergeFinishedFile.ReadLogRecord(size)
+	if err != nil || string(record.Key) != mergeCommitSeqKey {
+		return 0, false
+	}
+	commitSeq, err := strconv.ParseUint(string(record.Value), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return commitSeq, true
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
@@ -293,9 +366,11 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		// 解码拿到实际的位置索引
+		// 解码拿到实际的位置索引，已经过期的数据无需加载
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		if !pos.IsExpired() {
+			db.index.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 	return nil
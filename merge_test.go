@@ -4,6 +4,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"bitcask-kv/utils"
 	"github.com/stretchr/testify/assert"
@@ -180,6 +181,82 @@ func TestDB_Merge5(t *testing.T) {
 	_ = db2.Close()
 }
 
+// 存在已经过期的数据
+func TestDB_Merge6(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-6")
+	db, err := newTestMergeDB(dir)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(1024), 100*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 10000; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := newTestMergeDB(dir)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 10000, len(keys))
+	assert.Equal(t, 10000, db2.index.Size())
+}
+
+// 存在范围删除的数据
+func TestDB_Merge7(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-7")
+	db, err := newTestMergeDB(dir)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(0), utils.GetTestKey(10000))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 的过程中写入的范围删除在重启之后依然生效
+	err = db.DeleteRange(utils.GetTestKey(10000), utils.GetTestKey(15000))
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := newTestMergeDB(dir)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 5000, len(keys))
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(12000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(16000))
+	assert.Nil(t, err)
+}
+
 func newTestMergeDB(path string) (*DB, error) {
 	opts := DefaultOptions
 	opts.DataFileSize = 32 * 1024 * 1024
@@ -6,14 +6,32 @@ import (
 )
 
 type Options struct {
-	DirPath            string    // 数据库数据路径
-	DataFileSize       int64     // 数据文件大小
-	SyncWrites         bool      // 每次写数据是否持久化
-	BytesPerSync       uint      // 累计写了多少字节后进行持久化
-	IndexType          IndexType // 索引的类型
-	MMapAtStartup      bool      // 启动时是否使用 MMap 加载数据
-	DataFileMergeRatio float32   // 数据文件合并的阈值
-	mergeCheckInterval time.Duration // 合并检查的间隔
+	DirPath                 string                       // 数据库数据路径
+	DataFileSize            int64                        // 数据文件大小
+	SyncWrites              bool                         // 每次写数据是否持久化
+	BytesPerSync            uint                         // 累计写了多少字节后进行持久化
+	IndexType               IndexType                    // 索引的类型
+	MMapAtStartup           bool                         // 启动时是否使用 MMap 加载数据
+	DataFileMergeRatio      float32                      // 数据文件合并的阈值
+	ReadOnly                bool                         // 是否以只读方式打开
+	ReadOnlyRefreshInterval time.Duration                // 只读实例自动加载新写入数据的间隔，0 表示只能通过 Refresh 手动加载
+	MergeArchiveDir         string                       // merge 之后被替换的旧数据文件的归档目录，为空表示直接删除
+	RecoverUntil            *RecoverPoint                // 时间点恢复的目标，需要同时设置 ReadOnly
+	RecoveryPolicy          RecoveryPolicy               // 启动时发现数据文件损坏的处理方式
+	ScrubInterval           time.Duration                // 后台校验旧数据文件的间隔，0 表示不进行后台校验
+	ScrubBytesPerSecond     int64                        // 校验旧数据文件时每秒最多读取的字节数，0 表示不限制
+	OnScrubCorruption       func(region CorruptedRegion) // 校验发现损坏的数据时调用
+	ExpireCheckInterval     time.Duration                // 后台清理过期 key 的间隔，0 表示使用默认的间隔（1 秒）
+	mergeCheckInterval      time.Duration                // 合并检查的间隔
+	replica                 bool                         // 是否作为复制的从节点打开
+	noBackgroundTasks       bool                         // 不启动后台任务，用于 merge 时打开的临时实例
+}
+
+// RecoverPoint 时间点恢复的目标，Seq 和 Time 都设置时需要同时满足
+// 只加载在此之前提交的写入，包括 Options.MergeArchiveDir 中归档的 merge 之前的数据文件
+type RecoverPoint struct {
+	Seq  uint64    // 恢复到提交的全局序列号不大于 Seq 的写入，0 表示不限制
+	Time time.Time // 恢复到提交时间不晚于 Time 的写入，零值表示不限制
 }
 
 // IteratorOptions 索引迭代器的配置项
@@ -29,10 +47,98 @@ type WriteBatchOptions struct {
 	// 一个批次当中，最大的数据量
 	MaxBatchNum uint
 
+	// 一个批次当中，key 和 value 最大的总字节数，0 表示不限制
+	MaxBatchBytes int64
+
 	// 提交是否进行 Sync 持久化
 	SyncWrites bool
 }
 
+// TxnOptions 读写事务的配置项
+type TxnOptions struct {
+	// 一个事务当中，最大的写入数据量
+	MaxBatchNum uint
+
+	// 提交是否进行 Sync 持久化
+	SyncWrites bool
+
+	// 是否为悲观事务，悲观事务通过 key 锁实现隔离
+	Pessimistic bool
+
+	// 悲观事务等待 key 锁的超时时间
+	LockWaitTimeout time.Duration
+}
+
+// FollowerOptions 复制从节点的配置项
+type FollowerOptions struct {
+	// 主节点的复制服务地址
+	PrimaryAddr string
+
+	// 连接主节点的超时时间
+	DialTimeout time.Duration
+
+	// 连接断开之后重连的间隔
+	RetryInterval time.Duration
+}
+
+// BackupOptions 流式备份的配置项
+type BackupOptions struct {
+	// 是否使用 gzip 压缩备份流
+	Compress bool
+}
+
+// ExportOptions 导出数据的配置项
+type ExportOptions struct {
+	// 只导出前缀为指定值的 key，默认为空
+	Prefix []byte
+
+	// 是否将所有的 key 和 value 编码为 base64，默认只编码不是合法 UTF-8 的数据
+	Base64 bool
+
+	// 导出进度的回调，参数为已经导出的数据条数，为空表示不报告进度
+	Progress func(count int)
+}
+
+// ImportOptions 导入数据的配置项
+type ImportOptions struct {
+	// 每个 WriteBatch 中最多写入的 key 数量
+	BatchNum uint
+
+	// 每个批次提交时是否进行 Sync 持久化
+	SyncWrites bool
+
+	// 导入进度的回调，每提交一个批次调用一次，参数为已经写入的 key 数量，为空表示不报告进度
+	Progress func(count int)
+}
+
+// ExportFormat 导出和导入数据的格式
+type ExportFormat = int8
+
+const (
+	// ExportJSONLines 每行一个 JSON 对象
+	ExportJSONLines ExportFormat = iota
+
+	// ExportCSV 第一行为表头 key,value,encoding,expire
+	ExportCSV
+)
+
+// RecoveryPolicy 启动时从数据文件加载索引发现损坏数据的处理方式，处理的结果可以通过 DB.RecoveryReport 获取
+type RecoveryPolicy = int8
+
+const (
+	// RecoveryTruncateTail 截断活跃文件末尾没有写完整的记录，其他位置的损坏返回错误
+	RecoveryTruncateTail RecoveryPolicy = iota
+
+	// RecoveryStrict 发现任何损坏都返回错误，不修改数据文件
+	RecoveryStrict
+
+	// RecoverySkipCorrupted 截断活跃文件末尾没有写完整的记录，并跳过其他位置损坏的数据，从之后第一条有效的记录继续加载
+	RecoverySkipCorrupted
+
+	// RecoveryQuarantine 和 RecoverySkipCorrupted 相同，并将跳过的数据保存到数据目录下的 quarantine 目录中
+	RecoveryQuarantine
+)
+
 type IndexType = int8
 
 const (
@@ -47,14 +153,16 @@ const (
 )
 
 var DefaultOptions = Options{
-	DirPath:       os.TempDir(),
-	DataFileSize:  256 * 1024 * 1024, // 256MB
-	SyncWrites:    false,
-	BytesPerSync:  0,
-	IndexType:     Btree,
-	MMapAtStartup: true,
-	DataFileMergeRatio: 0.5,
-	mergeCheckInterval: 10 * time.Second,
+	DirPath:             os.TempDir(),
+	DataFileSize:        256 * 1024 * 1024, // 256MB
+	SyncWrites:          false,
+	BytesPerSync:        0,
+	IndexType:           Btree,
+	MMapAtStartup:       true,
+	DataFileMergeRatio:  0.5,
+	RecoveryPolicy:      RecoveryTruncateTail,
+	ExpireCheckInterval: defaultExpireCheckInterval,
+	mergeCheckInterval:  10 * time.Second,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -63,6 +171,34 @@ var DefaultIteratorOptions = IteratorOptions{
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
-	MaxBatchNum: 10000,
-	SyncWrites:  true,
+	MaxBatchNum:   10000,
+	MaxBatchBytes: 0,
+	SyncWrites:    true,
+}
+
+var DefaultTxnOptions = TxnOptions{
+	MaxBatchNum:     10000,
+	SyncWrites:      true,
+	Pessimistic:     false,
+	LockWaitTimeout: time.Second,
+}
+
+var DefaultFollowerOptions = FollowerOptions{
+	PrimaryAddr:   "127.0.0.1:7301",
+	DialTimeout:   5 * time.Second,
+	RetryInterval: time.Second,
+}
+
+var DefaultBackupOptions = BackupOptions{
+	Compress: false,
+}
+
+var DefaultExportOptions = ExportOptions{
+	Prefix: nil,
+	Base64: false,
+}
+
+var DefaultImportOptions = ImportOptions{
+	BatchNum:   10000,
+	SyncWrites: false,
 }
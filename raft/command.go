@@ -0,0 +1,156 @@
+package raft
+
+import (
+	bitcask "bitcask-kv"
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+type opType = byte
+
+const (
+	opPut opType = iota + 1
+	opDelete
+)
+
+// 状态机上的一个写操作
+type op struct {
+	typ   opType
+	key   []byte
+	value []byte
+}
+
+// 编码一组写操作，格式为 count | type | keySize | key | valueSize | value ...
+func encodeOps(ops []*op) []byte {
+	size := binary.MaxVarintLen64
+	for _, o := range ops {
+		size += 1 + binary.MaxVarintLen64*2 + len(o.key) + len(o.value)
+	}
+	buf := make([]byte, size)
+	index := binary.PutUvarint(buf, uint64(len(ops)))
+	for _, o := range ops {
+		buf[index] = o.typ
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(len(o.key)))
+		index += copy(buf[index:], o.key)
+		index += binary.PutUvarint(buf[index:], uint64(len(o.value)))
+		index += copy(buf[index:], o.value)
+	}
+	return buf[:index]
+}
+
+func decodeOps(buf []byte) ([]*op, error) {
+	errCorrupted := errors.New("corrupted raft command")
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errCorrupted
+	}
+	index := n
+	ops := make([]*op, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if index >= len(buf) {
+			return nil, errCorrupted
+		}
+		o := &op{typ: buf[index]}
+		index++
+		keySize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(keySize) > len(buf) {
+			return nil, errCorrupted
+		}
+		index += n
+		o.key = buf[index : index+int(keySize)]
+		index += int(keySize)
+		valueSize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(valueSize) > len(buf) {
+			return nil, errCorrupted
+		}
+		index += n
+		o.value = buf[index : index+int(valueSize)]
+		index += int(valueSize)
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+// 将一组写操作应用到状态机，多个操作通过 WriteBatch 原子写入
+func applyOps(db *bitcask.DB, ops []*op) error {
+	if len(ops) == 1 {
+		switch ops[0].typ {
+		case opPut:
+			return db.Put(ops[0].key, ops[0].value)
+		case opDelete:
+			return db.Delete(ops[0].key)
+		}
+	}
+
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.MaxBatchNum = uint(len(ops))
+	wb := db.NewWriteBatch(opts)
+	for _, o := range ops {
+		switch o.typ {
+		case opPut:
+			_ = wb.Put(o.key, o.value)
+		case opDelete:
+			_ = wb.Delete(o.key)
+		}
+	}
+	return wb.Commit()
+}
+
+// Put 写入数据，在多数节点提交并应用到 leader 的状态机之后返回
+func (n *Node) Put(ctx context.Context, key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(ctx, encodeOps([]*op{{typ: opPut, key: key, value: value}}))
+}
+
+// Delete 删除数据，在多数节点提交并应用到 leader 的状态机之后返回
+func (n *Node) Delete(ctx context.Context, key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(ctx, encodeOps([]*op{{typ: opDelete, key: key}}))
+}
+
+// WriteBatch 通过 raft 原子提交的批量写
+type WriteBatch struct {
+	node *Node
+	ops  []*op
+}
+
+// NewWriteBatch 初始化一个批量写
+func (n *Node) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{node: n}
+}
+
+// Put 批量写数据
+func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, &op{typ: opPut, key: key, value: value})
+	return nil
+}
+
+// Delete 删除数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, &op{typ: opDelete, key: key})
+	return nil
+}
+
+// Commit 提交批量写，所有写入作为一条 raft 日志提交
+func (wb *WriteBatch) Commit(ctx context.Context) error {
+	if len(wb.ops) == 0 {
+		return nil
+	}
+	if err := wb.node.propose(ctx, encodeOps(wb.ops)); err != nil {
+		return err
+	}
+	wb.ops = nil
+	return nil
+}
@@ -0,0 +1,11 @@
+package raft
+
+import "errors"
+
+var (
+	ErrNotLeader       = errors.New("the node is not the leader")
+	ErrNodeClosed      = errors.New("the raft node is closed")
+	ErrProposalDropped = errors.New("the proposal was dropped, it may or may not have been committed")
+	ErrUnreachable     = errors.New("the target node is unreachable")
+	ErrInvalidConfig   = errors.New("invalid raft config")
+)
@@ -0,0 +1,40 @@
+package raft
+
+import (
+	bitcask "bitcask-kv"
+	"time"
+)
+
+// Config raft 节点的配置项
+type Config struct {
+	// 节点 id，在集群中唯一
+	ID string
+
+	// 集群中所有节点的 id，包括自身
+	Peers []string
+
+	// 节点的数据目录，存储状态机数据、raft 日志和快照
+	DirPath string
+
+	// 状态机 bitcask 实例的配置项，DirPath 会被忽略
+	DBOptions bitcask.Options
+
+	// 选举超时时间，实际的超时时间在 [ElectionTimeout, 2*ElectionTimeout) 之间随机
+	ElectionTimeout time.Duration
+
+	// leader 发送心跳的间隔，需要远小于选举超时时间
+	HeartbeatInterval time.Duration
+
+	// 应用了多少条日志之后生成一次快照，0 表示不生成快照
+	SnapshotThreshold uint64
+
+	// 节点之间通信的传输层
+	Transport Transport
+}
+
+var DefaultConfig = Config{
+	DBOptions:         bitcask.DefaultOptions,
+	ElectionTimeout:   300 * time.Millisecond,
+	HeartbeatInterval: 50 * time.Millisecond,
+	SnapshotThreshold: 10000,
+}
@@ -0,0 +1,930 @@
+package raft
+
+import (
+	bitcask "bitcask-kv"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dataDirName     = "data"
+	raftDirName     = "raft"
+	snapshotDirName = "snapshot"
+
+	// 单次 AppendEntries 最多携带的日志条目数量
+	maxEntriesPerAppend = 512
+)
+
+// State 节点的角色
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+// 等待提交的写入
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+// Node 一个 raft 节点，写入在多数节点提交之后才会应用到 bitcask 状态机
+type Node struct {
+	cfg     Config
+	mtx     *sync.Mutex
+	dbMtx   *sync.RWMutex // 保护状态机实例，应用日志和安装快照时持有写锁
+	db      *bitcask.DB   // 状态机
+	storage *storage      // 持久化的 raft 状态
+	snapMtx *sync.RWMutex // 保护磁盘上的快照目录，替换快照时持有写锁
+
+	receiver *snapshotReceiver // 接收 leader 发送的快照
+
+	state       State
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+	log         []*Entry // log[0] 为快照包含的最后一条日志，只使用其 Index 和 Term
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	replicating map[string]bool
+
+	lastContact     time.Time // 最近一次收到 leader 消息或者投票的时间
+	lastBroadcast   time.Time // leader 最近一次发送心跳的时间
+	electionTimeout time.Duration
+
+	proposals   map[uint64]*proposal
+	applyNotify chan struct{} // 应用日志之后关闭，用于唤醒等待读取的请求
+	commitCh    chan struct{}
+	stopCh      chan struct{}
+	wg          *sync.WaitGroup
+	closed      bool
+	err         error // 导致节点停止的错误
+}
+
+// NewNode 打开一个 raft 节点，并开始参与选举
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.ID == "" || len(cfg.Peers) == 0 || cfg.Transport == nil || cfg.DirPath == "" {
+		return nil, ErrInvalidConfig
+	}
+	if err := os.MkdirAll(cfg.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		cfg:         cfg,
+		mtx:         new(sync.Mutex),
+		dbMtx:       new(sync.RWMutex),
+		snapMtx:     new(sync.RWMutex),
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		replicating: make(map[string]bool),
+		proposals:   make(map[uint64]*proposal),
+		applyNotify: make(chan struct{}),
+		commitCh:    make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		wg:          new(sync.WaitGroup),
+	}
+
+	st, err := openStorage(filepath.Join(cfg.DirPath, raftDirName), cfg.DBOptions.SyncWrites)
+	if err != nil {
+		return nil, err
+	}
+	n.storage = st
+	n.receiver = newSnapshotReceiver(n.snapshotPath() + ".recv")
+	if err := n.loadState(); err != nil {
+		_ = st.close()
+		return nil, err
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		_ = st.close()
+		return nil, err
+	}
+	n.db = db
+
+	n.resetElectionTimer()
+	cfg.Transport.Register(n)
+
+	n.wg.Add(2)
+	go n.runTicker()
+	go n.runApplier()
+	return n, nil
+}
+
+// 加载持久化的任期、投票、快照和日志
+func (n *Node) loadState() error {
+	term, votedFor, err := n.storage.loadState()
+	if err != nil {
+		return err
+	}
+	snapIndex, snapTerm, err := n.storage.loadSnapshotMeta()
+	if err != nil {
+		return err
+	}
+	entries, err := n.storage.loadEntries()
+	if err != nil {
+		return err
+	}
+
+	n.currentTerm, n.votedFor = term, votedFor
+	n.log = append([]*Entry{{Index: snapIndex, Term: snapTerm}}, entries...)
+	// 状态机中可能已经应用了快照之后的日志，重新应用是幂等的
+	n.commitIndex, n.lastApplied = snapIndex, snapIndex
+	return nil
+}
+
+func (n *Node) openDB() (*bitcask.DB, error) {
+	opts := n.cfg.DBOptions
+	opts.DirPath = n.dataPath()
+	return bitcask.Open(opts)
+}
+
+func (n *Node) dataPath() string {
+	return filepath.Join(n.cfg.DirPath, dataDirName)
+}
+
+func (n *Node) snapshotPath() string {
+	return filepath.Join(n.cfg.DirPath, snapshotDirName)
+}
+
+// ID 节点 id
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// State 节点当前的角色和任期
+func (n *Node) State() (State, uint64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.state, n.currentTerm
+}
+
+// Leader 当前已知的 leader id，未知时为空
+func (n *Node) Leader() string {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.leaderID
+}
+
+// Get 线性一致地读取数据，只能在 leader 上调用
+// 通过 ReadIndex 确认自己仍然是 leader，并等待状态机应用到确认时的提交位置之后再读取
+func (n *Node) Get(ctx context.Context, key []byte) ([]byte, error) {
+	readIndex, err := n.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.waitApplied(ctx, readIndex); err != nil {
+		return nil, err
+	}
+	n.dbMtx.RLock()
+	defer n.dbMtx.RUnlock()
+	if n.db == nil {
+		return nil, ErrNodeClosed
+	}
+	return n.db.Get(key)
+}
+
+// StaleGet 直接读取本地状态机中的数据，可能读取到旧的数据
+func (n *Node) StaleGet(key []byte) ([]byte, error) {
+	n.dbMtx.RLock()
+	defer n.dbMtx.RUnlock()
+	if n.db == nil {
+		return nil, ErrNodeClosed
+	}
+	return n.db.Get(key)
+}
+
+// Close 停止节点并关闭状态机，节点因为出错已经停止时返回该错误
+func (n *Node) Close() error {
+	n.mtx.Lock()
+	if n.closed {
+		n.mtx.Unlock()
+		return nil
+	}
+	n.closed = true
+	if !n.stopped() {
+		close(n.stopCh)
+	}
+	n.mtx.Unlock()
+
+	_ = n.cfg.Transport.Close()
+	n.wg.Wait()
+
+	n.dbMtx.Lock()
+	defer n.dbMtx.Unlock()
+	n.receiver.mtx.Lock()
+	n.receiver.reset()
+	n.receiver.mtx.Unlock()
+	if n.db != nil {
+		if err := n.db.Close(); err != nil {
+			return err
+		}
+	}
+	if err := n.storage.close(); err != nil {
+		return err
+	}
+	return n.err
+}
+
+// 以下日志相关的方法在访问前必须持有互斥锁
+
+func (n *Node) snapshotIndex() uint64 {
+	return n.log[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) entry(index uint64) *Entry {
+	return n.log[index-n.snapshotIndex()]
+}
+
+// 丢弃 index 及之前的日志，index 位置的日志作为新的 log[0]
+func (n *Node) compactLog(index, term uint64) {
+	if index <= n.lastIndex() && n.entry(index).Term == term {
+		n.log = append([]*Entry{{Index: index, Term: term}}, n.log[index-n.snapshotIndex()+1:]...)
+	} else {
+		n.log = []*Entry{{Index: index, Term: term}}
+	}
+}
+
+func (n *Node) resetElectionTimer() {
+	n.lastContact = time.Now()
+	n.electionTimeout = n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+}
+
+// 节点是否已经关闭，关闭之后不再修改持久化的状态
+// 在访问此方法前必须持有互斥锁
+func (n *Node) stopped() bool {
+	select {
+	case <-n.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// 持久化或者状态机出错时停止节点，之后的请求返回 ErrNodeClosed，Close 返回该错误
+// 在访问此方法前必须持有互斥锁
+func (n *Node) stop(err error) {
+	if n.stopped() {
+		return
+	}
+	n.err = err
+	n.state = Follower
+	close(n.stopCh)
+}
+
+// 持久化失败时无法保证安全性，停止节点
+func (n *Node) persistState() error {
+	if err := n.storage.saveState(n.currentTerm, n.votedFor); err != nil {
+		n.stop(err)
+		return err
+	}
+	return nil
+}
+
+// 任期变化时持久化失败会停止节点，调用之后需要检查节点是否已经停止
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.persistState()
+	}
+	n.state = Follower
+}
+
+func (n *Node) signalCommit() {
+	select {
+	case n.commitCh <- struct{}{}:
+	default:
+	}
+}
+
+// 定时检查选举超时，leader 定时发送心跳
+func (n *Node) runTicker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mtx.Lock()
+		if n.state == Leader {
+			if time.Since(n.lastBroadcast) >= n.cfg.HeartbeatInterval {
+				n.broadcast()
+			}
+		} else if time.Since(n.lastContact) >= n.electionTimeout {
+			n.startElection()
+		}
+		n.mtx.Unlock()
+	}
+}
+
+// 发起选举
+// 在访问此方法前必须持有互斥锁
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.currentTerm++
+	n.votedFor = n.cfg.ID
+	n.leaderID = ""
+	if err := n.persistState(); err != nil {
+		return
+	}
+	n.resetElectionTimer()
+
+	term := n.currentTerm
+	req := &RequestVoteRequest{
+		Term:         term,
+		CandidateID:  n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if votes*2 > len(n.cfg.Peers) {
+		n.becomeLeader()
+		return
+	}
+
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.ID {
+			continue
+		}
+		go func(peer string) {
+			resp, err := n.cfg.Transport.RequestVote(context.Background(), peer, req)
+			if err != nil {
+				return
+			}
+			n.mtx.Lock()
+			defer n.mtx.Unlock()
+			if n.stopped() {
+				return
+			}
+			if resp.Term > n.currentTerm {
+				n.becomeFollower(resp.Term)
+				return
+			}
+			if n.state != Candidate || n.currentTerm != term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes*2 > len(n.cfg.Peers) {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// 成为 leader，写入一条当前任期的空日志，用于提交之前任期的日志和 ReadIndex
+// 在访问此方法前必须持有互斥锁
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leaderID = n.cfg.ID
+	for _, peer := range n.cfg.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	if _, err := n.appendEntry(nil); err != nil {
+		return
+	}
+	n.broadcast()
+}
+
+// leader 追加一条日志，持久化失败时停止节点
+// 在访问此方法前必须持有互斥锁
+func (n *Node) appendEntry(data []byte) (*Entry, error) {
+	entry := &Entry{Index: n.lastIndex() + 1, Term: n.currentTerm, Data: data}
+	if err := n.storage.appendEntries([]*Entry{entry}); err != nil {
+		n.stop(err)
+		return nil, err
+	}
+	n.log = append(n.log, entry)
+	n.matchIndex[n.cfg.ID] = entry.Index
+	n.advanceCommitIndex()
+	return entry, nil
+}
+
+// 向所有 follower 发送日志或心跳
+// 在访问此方法前必须持有互斥锁
+func (n *Node) broadcast() {
+	n.lastBroadcast = time.Now()
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.ID || n.replicating[peer] {
+			continue
+		}
+		n.replicating[peer] = true
+		go n.replicateTo(peer)
+	}
+}
+
+// 向一个 follower 复制日志，直到对方追上或者出错
+func (n *Node) replicateTo(peer string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	defer func() { n.replicating[peer] = false }()
+
+	for {
+		if n.state != Leader || n.stopped() {
+			return
+		}
+
+		term := n.currentTerm
+		next := n.nextIndex[peer]
+		if next <= n.snapshotIndex() {
+			// follower 需要的日志已经被压缩，发送快照
+			n.mtx.Unlock()
+			snapIndex, resp, err := n.sendSnapshot(peer, term)
+			n.mtx.Lock()
+			if err != nil || n.stopped() {
+				return
+			}
+			if resp.Term > n.currentTerm {
+				n.becomeFollower(resp.Term)
+				return
+			}
+			if n.state != Leader || n.currentTerm != term || !resp.Success {
+				return
+			}
+			n.matchIndex[peer] = max(n.matchIndex[peer], snapIndex)
+			n.nextIndex[peer] = n.matchIndex[peer] + 1
+			continue
+		}
+
+		req := n.appendEntriesRequest(peer)
+		n.mtx.Unlock()
+		resp, err := n.cfg.Transport.AppendEntries(context.Background(), peer, req)
+		n.mtx.Lock()
+		if err != nil || n.stopped() {
+			return
+		}
+		if resp.Term > n.currentTerm {
+			n.becomeFollower(resp.Term)
+			return
+		}
+		if n.state != Leader || n.currentTerm != term {
+			return
+		}
+
+		if resp.Success {
+			match := req.PrevLogIndex + uint64(len(req.Entries))
+			n.matchIndex[peer] = max(n.matchIndex[peer], match)
+			n.nextIndex[peer] = n.matchIndex[peer] + 1
+			n.advanceCommitIndex()
+			if n.nextIndex[peer] > n.lastIndex() {
+				return
+			}
+			continue
+		}
+
+		// 日志不匹配，回退之后重试
+		if resp.ConflictIndex > 0 && resp.ConflictIndex < next {
+			n.nextIndex[peer] = resp.ConflictIndex
+		} else if next > 1 {
+			n.nextIndex[peer] = next - 1
+		}
+	}
+}
+
+// 构造发送给 follower 的 AppendEntries 请求
+// 在访问此方法前必须持有互斥锁
+func (n *Node) appendEntriesRequest(peer string) *AppendEntriesRequest {
+	next := max(n.nextIndex[peer], n.snapshotIndex()+1)
+	prev := n.entry(next - 1)
+	end := min(n.lastIndex()+1, next+maxEntriesPerAppend)
+	entries := make([]*Entry, 0, end-next)
+	for i := next; i < end; i++ {
+		entries = append(entries, n.entry(i))
+	}
+	return &AppendEntriesRequest{
+		Term:         n.currentTerm,
+		LeaderID:     n.cfg.ID,
+		PrevLogIndex: prev.Index,
+		PrevLogTerm:  prev.Term,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// 多数节点已经复制的当前任期的日志可以提交
+// 在访问此方法前必须持有互斥锁
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex && index > n.snapshotIndex(); index-- {
+		if n.entry(index).Term != n.currentTerm {
+			break
+		}
+		count := 0
+		for _, peer := range n.cfg.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count*2 > len(n.cfg.Peers) {
+			n.commitIndex = index
+			n.signalCommit()
+			return
+		}
+	}
+}
+
+// HandleRequestVote 处理投票请求
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) *RequestVoteResponse {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	resp := &RequestVoteResponse{Term: n.currentTerm}
+	if n.stopped() {
+		return resp
+	}
+	if req.Term > n.currentTerm {
+		n.becomeFollower(req.Term)
+		resp.Term = n.currentTerm
+	}
+	if req.Term < n.currentTerm || n.stopped() {
+		return resp
+	}
+
+	// 候选人的日志至少和自己一样新才能投票
+	upToDate := req.LastLogTerm > n.lastTerm() ||
+		(req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		if err := n.persistState(); err != nil {
+			return resp
+		}
+		n.resetElectionTimer()
+		resp.VoteGranted = true
+	}
+	return resp
+}
+
+// HandleAppendEntries 处理 leader 发送的日志和心跳
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	resp := &AppendEntriesResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm || n.stopped() {
+		return resp
+	}
+	n.becomeFollower(req.Term)
+	resp.Term = n.currentTerm
+	if n.stopped() {
+		return resp
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	// 快照之前的日志一定已经提交，跳过
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if prevIndex < n.snapshotIndex() {
+		skip := n.snapshotIndex() - prevIndex
+		if uint64(len(entries)) <= skip {
+			resp.Success = true
+			return resp
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snapshotIndex(), n.log[0].Term
+	}
+
+	if prevIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+	if term := n.entry(prevIndex).Term; term != prevTerm {
+		// 跳过整个冲突的任期
+		index := prevIndex
+		for index > n.snapshotIndex()+1 && n.entry(index-1).Term == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	// 追加新的日志，删除冲突的日志
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.entry(entry.Index).Term == entry.Term {
+				continue
+			}
+			if err := n.storage.truncateFrom(entry.Index); err != nil {
+				n.stop(err)
+				return resp
+			}
+			n.log = n.log[:entry.Index-n.snapshotIndex()]
+		}
+		if err := n.storage.appendEntries(entries[i:]); err != nil {
+			n.stop(err)
+			return resp
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	lastNewIndex := prevIndex + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = min(req.LeaderCommit, lastNewIndex)
+		n.signalCommit()
+	}
+	resp.Success = true
+	return resp
+}
+
+// HandleInstallSnapshot 处理 leader 发送的快照块，接收完整之后安装快照
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	n.mtx.Lock()
+	resp := &InstallSnapshotResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm || n.stopped() {
+		n.mtx.Unlock()
+		return resp
+	}
+	n.becomeFollower(req.Term)
+	resp.Term = n.currentTerm
+	if n.stopped() {
+		n.mtx.Unlock()
+		return resp
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+	if req.LastIncludedIndex <= n.lastApplied {
+		// 已经包含快照中的数据，忽略剩余的块
+		n.mtx.Unlock()
+		resp.Success = true
+		return resp
+	}
+	n.mtx.Unlock()
+
+	n.receiver.mtx.Lock()
+	defer n.receiver.mtx.Unlock()
+	done, err := n.receiver.receive(req)
+	if err != nil {
+		// 丢弃接收到一半的快照，leader 会从第一块重新发送
+		n.receiver.reset()
+		return resp
+	}
+	resp.Success = true
+	if !done {
+		return resp
+	}
+	n.receiver.reset()
+
+	// 替换状态机时不能同时应用日志
+	n.dbMtx.Lock()
+	defer n.dbMtx.Unlock()
+	n.mtx.Lock()
+	if n.stopped() || req.LastIncludedIndex <= n.lastApplied {
+		n.mtx.Unlock()
+		return resp
+	}
+	n.mtx.Unlock()
+	installErr := n.installSnapshot(n.receiver.dirPath)
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if installErr != nil {
+		n.stop(installErr)
+		resp.Success = false
+		return resp
+	}
+	index, term := req.LastIncludedIndex, req.LastIncludedTerm
+	n.compactLog(index, term)
+	if err := n.storage.truncateFrom(0); err != nil {
+		n.stop(err)
+		resp.Success = false
+		return resp
+	}
+	if err := n.storage.appendEntries(n.log[1:]); err != nil {
+		n.stop(err)
+		resp.Success = false
+		return resp
+	}
+	if err := n.storage.saveSnapshotMeta(index, term); err != nil {
+		n.stop(err)
+		resp.Success = false
+		return resp
+	}
+	n.commitIndex = max(n.commitIndex, index)
+	n.lastApplied = index
+	for i, p := range n.proposals {
+		if i <= index {
+			p.done <- ErrProposalDropped
+			delete(n.proposals, i)
+		}
+	}
+	n.notifyApplied()
+	return resp
+}
+
+// 提交一条写入，等待其被应用到状态机
+func (n *Node) propose(ctx context.Context, data []byte) error {
+	n.mtx.Lock()
+	if n.stopped() {
+		n.mtx.Unlock()
+		return ErrNodeClosed
+	}
+	if n.state != Leader {
+		n.mtx.Unlock()
+		return ErrNotLeader
+	}
+	entry, err := n.appendEntry(data)
+	if err != nil {
+		n.mtx.Unlock()
+		return err
+	}
+	p := &proposal{term: entry.Term, done: make(chan error, 1)}
+	n.proposals[entry.Index] = p
+	n.broadcast()
+	n.mtx.Unlock()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		n.mtx.Lock()
+		delete(n.proposals, entry.Index)
+		n.mtx.Unlock()
+		return ctx.Err()
+	case <-n.stopCh:
+		return ErrNodeClosed
+	}
+}
+
+// 将已经提交的日志应用到状态机
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.commitCh:
+		}
+		n.applyCommitted()
+	}
+}
+
+func (n *Node) applyCommitted() {
+	n.dbMtx.Lock()
+	defer n.dbMtx.Unlock()
+
+	n.mtx.Lock()
+	if n.stopped() {
+		n.mtx.Unlock()
+		return
+	}
+	var entries []*Entry
+	for index := n.lastApplied + 1; index <= n.commitIndex; index++ {
+		entries = append(entries, n.entry(index))
+	}
+	n.mtx.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	results := make([]error, len(entries))
+	for i, entry := range entries {
+		if len(entry.Data) == 0 {
+			continue
+		}
+		ops, err := decodeOps(entry.Data)
+		if err == nil {
+			err = applyOps(n.db, ops)
+		}
+		results[i] = err
+	}
+
+	n.mtx.Lock()
+	last := entries[len(entries)-1]
+	n.lastApplied = last.Index
+	for i, entry := range entries {
+		p, ok := n.proposals[entry.Index]
+		if !ok {
+			continue
+		}
+		// 同一个位置的日志已经被新的 leader 覆盖
+		if p.term != entry.Term {
+			p.done <- ErrProposalDropped
+		} else {
+			p.done <- results[i]
+		}
+		delete(n.proposals, entry.Index)
+	}
+	n.notifyApplied()
+	needSnapshot := n.cfg.SnapshotThreshold > 0 && last.Index-n.snapshotIndex() >= n.cfg.SnapshotThreshold
+	n.mtx.Unlock()
+
+	if needSnapshot {
+		if err := n.takeSnapshot(last.Index, last.Term); err != nil {
+			n.mtx.Lock()
+			n.stop(err)
+			n.mtx.Unlock()
+			return
+		}
+	}
+	// 应用期间可能有新的日志提交
+	n.signalCommit()
+}
+
+// 唤醒等待状态机应用的请求
+// 在访问此方法前必须持有互斥锁
+func (n *Node) notifyApplied() {
+	close(n.applyNotify)
+	n.applyNotify = make(chan struct{})
+}
+
+// 等待状态机应用到 index
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mtx.Lock()
+		if n.lastApplied >= index {
+			n.mtx.Unlock()
+			return nil
+		}
+		notify := n.applyNotify
+		n.mtx.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stopCh:
+			return ErrNodeClosed
+		}
+	}
+}
+
+// 获取 ReadIndex，确认自己仍然是多数节点认可的 leader
+func (n *Node) readIndex(ctx context.Context) (uint64, error) {
+	// 当前任期的日志提交之后，提交位置才是最新的
+	for {
+		n.mtx.Lock()
+		if n.state != Leader {
+			n.mtx.Unlock()
+			return 0, ErrNotLeader
+		}
+		if n.entry(n.commitIndex).Term == n.currentTerm {
+			break
+		}
+		notify := n.applyNotify
+		n.mtx.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-n.stopCh:
+			return 0, ErrNodeClosed
+		}
+	}
+
+	readIndex, term := n.commitIndex, n.currentTerm
+	requests := make(map[string]*AppendEntriesRequest)
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.ID && n.nextIndex[peer] > n.snapshotIndex() {
+			req := n.appendEntriesRequest(peer)
+			req.Entries = nil
+			requests[peer] = req
+		}
+	}
+	n.mtx.Unlock()
+
+	// 向 follower 发送心跳，多数节点认可当前任期则确认仍然是 leader
+	acks := make(chan bool, len(requests))
+	for peer, req := range requests {
+		go func(peer string, req *AppendEntriesRequest) {
+			resp, err := n.cfg.Transport.AppendEntries(ctx, peer, req)
+			acks <- err == nil && resp.Term == term
+		}(peer, req)
+	}
+	count := 1
+	for i := 0; i < len(requests) && count*2 <= len(n.cfg.Peers); i++ {
+		select {
+		case ok := <-acks:
+			if ok {
+				count++
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	if count*2 <= len(n.cfg.Peers) {
+		return 0, ErrNotLeader
+	}
+	return readIndex, nil
+}
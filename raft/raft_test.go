@@ -0,0 +1,312 @@
+package raft
+
+import (
+	bitcask "bitcask-kv"
+	"bitcask-kv/utils"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCluster struct {
+	t       *testing.T
+	dir     string
+	network *InMemNetwork
+	peers   []string
+	nodes   map[string]*Node
+	cfg     Config
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	dir, _ := os.MkdirTemp("", "bitcask-go-raft")
+	c := &testCluster{
+		t:       t,
+		dir:     dir,
+		network: NewInMemNetwork(),
+		nodes:   make(map[string]*Node),
+	}
+	for i := 0; i < size; i++ {
+		c.peers = append(c.peers, fmt.Sprintf("node-%d", i))
+	}
+	c.cfg = DefaultConfig
+	c.cfg.ElectionTimeout = 150 * time.Millisecond
+	c.cfg.HeartbeatInterval = 30 * time.Millisecond
+	c.cfg.SnapshotThreshold = snapshotThreshold
+	for _, id := range c.peers {
+		c.start(id)
+	}
+	return c
+}
+
+func (c *testCluster) start(id string) {
+	cfg := c.cfg
+	cfg.ID = id
+	cfg.Peers = c.peers
+	cfg.DirPath = filepath.Join(c.dir, id)
+	cfg.Transport = c.network.Transport(id)
+	node, err := NewNode(cfg)
+	assert.Nil(c.t, err)
+	c.nodes[id] = node
+}
+
+func (c *testCluster) close() {
+	for _, node := range c.nodes {
+		_ = node.Close()
+	}
+	_ = os.RemoveAll(c.dir)
+}
+
+// 等待选出 leader，忽略 excluded 中的节点
+func (c *testCluster) waitLeader(excluded ...string) *Node {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		for id, node := range c.nodes {
+			skip := false
+			for _, e := range excluded {
+				skip = skip || e == id
+			}
+			if state, _ := node.State(); !skip && state == Leader {
+				return node
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	c.t.Fatal("no leader elected")
+	return nil
+}
+
+// 等待所有节点的状态机中都有 key 对应的值
+func (c *testCluster) waitReplicated(key, value []byte, ids ...string) {
+	deadline := time.Now().Add(time.Second * 5)
+	for _, id := range ids {
+		for {
+			val, err := c.nodes[id].StaleGet(key)
+			if err == nil && string(val) == string(value) {
+				break
+			}
+			if time.Now().After(deadline) {
+				c.t.Fatalf("key %s not replicated to %s", key, id)
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+}
+
+func TestCluster_Replicate(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	leader := c.waitLeader()
+	for i := 0; i < 100; i++ {
+		err := leader.Put(ctx, utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+	}
+	err := leader.Delete(ctx, utils.GetTestKey(0))
+	assert.Nil(t, err)
+	wb := leader.NewWriteBatch()
+	_ = wb.Put([]byte("batch-1"), []byte("v1"))
+	_ = wb.Put([]byte("batch-2"), []byte("v2"))
+	_ = wb.Delete(utils.GetTestKey(1))
+	err = wb.Commit(ctx)
+	assert.Nil(t, err)
+
+	// leader 上线性一致读
+	val, err := leader.Get(ctx, utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-99"), val)
+	_, err = leader.Get(ctx, utils.GetTestKey(0))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = leader.Get(ctx, utils.GetTestKey(1))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	c.waitReplicated([]byte("batch-2"), []byte("v2"), c.peers...)
+
+	// follower 拒绝写入和线性一致读
+	for _, node := range c.nodes {
+		if node == leader {
+			continue
+		}
+		assert.Equal(t, ErrNotLeader, node.Put(ctx, []byte("key"), []byte("value")))
+		_, err := node.Get(ctx, []byte("batch-1"))
+		assert.Equal(t, ErrNotLeader, err)
+		assert.Equal(t, leader.ID(), node.Leader())
+	}
+}
+
+func TestCluster_LeaderFailure(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	leader := c.waitLeader()
+	err := leader.Put(ctx, []byte("before"), []byte("value"))
+	assert.Nil(t, err)
+
+	// leader 和其他节点断开，剩余的节点选出新的 leader
+	c.network.Disconnect(leader.ID())
+	newLeader := c.waitLeader(leader.ID())
+	assert.NotEqual(t, leader.ID(), newLeader.ID())
+
+	err = newLeader.Put(ctx, []byte("after"), []byte("value"))
+	assert.Nil(t, err)
+	val, err := newLeader.Get(ctx, []byte("before"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 旧的 leader 无法提交写入，也无法确认 ReadIndex
+	shortCtx, shortCancel := context.WithTimeout(ctx, time.Millisecond*300)
+	err = leader.Put(shortCtx, []byte("lost"), []byte("value"))
+	shortCancel()
+	assert.NotNil(t, err)
+	shortCtx, shortCancel = context.WithTimeout(ctx, time.Millisecond*300)
+	_, err = leader.Get(shortCtx, []byte("before"))
+	shortCancel()
+	assert.NotNil(t, err)
+
+	// 恢复网络之后旧的 leader 追上新的数据，未提交的写入被丢弃
+	c.network.Reconnect(leader.ID())
+	c.waitReplicated([]byte("after"), []byte("value"), c.peers...)
+	_, err = leader.StaleGet([]byte("lost"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestCluster_Snapshot(t *testing.T) {
+	c := newTestCluster(t, 3, 50)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	leader := c.waitLeader()
+	var lagging string
+	for _, id := range c.peers {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.network.Disconnect(lagging)
+
+	for i := 0; i < 200; i++ {
+		err := leader.Put(ctx, utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+	}
+	leader.mtx.Lock()
+	assert.True(t, leader.snapshotIndex() > 0)
+	leader.mtx.Unlock()
+
+	// 落后的节点通过快照追上
+	c.network.Reconnect(lagging)
+	c.waitReplicated(utils.GetTestKey(199), []byte("value-199"), lagging)
+	for i := 0; i < 200; i++ {
+		val, err := c.nodes[lagging].StaleGet(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+
+	// 重启之后从快照和日志中恢复
+	id := leader.ID()
+	assert.Nil(t, leader.Close())
+	c.start(id)
+	newLeader := c.waitLeader()
+	err := newLeader.Put(ctx, []byte("after-restart"), []byte("value"))
+	assert.Nil(t, err)
+	c.waitReplicated([]byte("after-restart"), []byte("value"), c.peers...)
+	c.waitReplicated(utils.GetTestKey(199), []byte("value-199"), id)
+}
+
+func TestCluster_SnapshotChunks(t *testing.T) {
+	chunkSize := snapshotChunkSize
+	snapshotChunkSize = 128
+	defer func() { snapshotChunkSize = chunkSize }()
+
+	c := newTestCluster(t, 3, 50)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	leader := c.waitLeader()
+	var lagging string
+	for _, id := range c.peers {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.network.Disconnect(lagging)
+	for i := 0; i < 200; i++ {
+		err := leader.Put(ctx, utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// 快照远大于块的大小，分成多块发送
+	c.network.Reconnect(lagging)
+	c.waitReplicated(utils.GetTestKey(199), []byte("value-199"), lagging)
+	for i := 0; i < 200; i++ {
+		val, err := c.nodes[lagging].StaleGet(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+}
+
+func TestSnapshotReceiver(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-raft-recv")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	r := newSnapshotReceiver(filepath.Join(dir, "recv"))
+
+	// 没有收到第一块之前拒绝后续的块
+	_, err := r.receive(&InstallSnapshotRequest{LastIncludedIndex: 10, Chunk: 1, FileName: "a", Data: []byte("x")})
+	assert.Equal(t, errSnapshotChunkMismatch, err)
+
+	done, err := r.receive(&InstallSnapshotRequest{LastIncludedIndex: 10, Chunk: 0, FileName: "a", Data: []byte("aa")})
+	assert.Nil(t, err)
+	assert.False(t, done)
+	done, err = r.receive(&InstallSnapshotRequest{LastIncludedIndex: 10, Chunk: 1, FileName: "a", Data: []byte("bb")})
+	assert.Nil(t, err)
+	assert.False(t, done)
+
+	// 属于其他快照或者乱序的块被拒绝
+	_, err = r.receive(&InstallSnapshotRequest{LastIncludedIndex: 11, Chunk: 2, FileName: "b", Data: []byte("x")})
+	assert.Equal(t, errSnapshotChunkMismatch, err)
+	_, err = r.receive(&InstallSnapshotRequest{LastIncludedIndex: 10, Chunk: 3, FileName: "b", Data: []byte("x")})
+	assert.Equal(t, errSnapshotChunkMismatch, err)
+
+	done, err = r.receive(&InstallSnapshotRequest{LastIncludedIndex: 10, Chunk: 2, FileName: "b", Data: []byte("cc"), Done: true})
+	assert.Nil(t, err)
+	assert.True(t, done)
+
+	content, err := os.ReadFile(filepath.Join(dir, "recv", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("aabb"), content)
+	content, err = os.ReadFile(filepath.Join(dir, "recv", "b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("cc"), content)
+}
+
+func TestNode_StopOnStorageError(t *testing.T) {
+	c := newTestCluster(t, 1, 0)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	leader := c.waitLeader()
+	err := leader.Put(ctx, []byte("key"), []byte("value"))
+	assert.Nil(t, err)
+
+	// raft 日志无法持久化时停止节点，而不是让进程崩溃
+	_ = leader.storage.db.Close()
+	err = leader.Put(ctx, []byte("key"), []byte("value-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrNodeClosed, leader.Put(ctx, []byte("key"), []byte("value-3")))
+
+	assert.NotNil(t, leader.Close())
+}
@@ -0,0 +1,265 @@
+package raft
+
+import (
+	"bitcask-kv/utils"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// 发送快照时每一块的最大字节数
+var snapshotChunkSize int64 = 1 << 20
+
+var errSnapshotChunkMismatch = errors.New("unexpected snapshot chunk")
+
+// 快照中的一个文件
+type snapshotFile struct {
+	name string
+	file *os.File
+	size int64
+}
+
+// 打开快照目录中的所有文件，按照文件名排序
+// 文件打开之后即使快照目录被替换，仍然可以读取到打开时的内容
+func openSnapshotFiles(dirPath string) ([]*snapshotFile, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var files []*snapshotFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			closeSnapshotFiles(files)
+			return nil, err
+		}
+		files = append(files, &snapshotFile{name: entry.Name(), file: file})
+		stat, err := file.Stat()
+		if err != nil {
+			closeSnapshotFiles(files)
+			return nil, err
+		}
+		files[len(files)-1].size = stat.Size()
+	}
+	return files, nil
+}
+
+func closeSnapshotFiles(files []*snapshotFile) {
+	for _, f := range files {
+		_ = f.file.Close()
+	}
+}
+
+// 使用 src 目录替换 dest 目录
+func replaceDir(src, dest string) error {
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Rename(src, dest)
+}
+
+// 生成快照，拷贝状态机的数据目录
+// 在访问此方法前必须持有状态机的写锁
+func (n *Node) takeSnapshot(index, term uint64) error {
+	tmpPath := n.snapshotPath() + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+	if err := n.db.Backup(tmpPath); err != nil {
+		return err
+	}
+
+	n.snapMtx.Lock()
+	defer n.snapMtx.Unlock()
+	if err := replaceDir(tmpPath, n.snapshotPath()); err != nil {
+		return err
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if index <= n.snapshotIndex() {
+		return nil
+	}
+	n.compactLog(index, term)
+	if err := n.storage.saveSnapshotMeta(index, term); err != nil {
+		return err
+	}
+	return n.storage.compactTo(index)
+}
+
+// 将磁盘上的快照分块发送给 follower，返回发送的快照包含的最后一条日志
+func (n *Node) sendSnapshot(peer string, term uint64) (uint64, *InstallSnapshotResponse, error) {
+	// 快照目录和快照元数据一起读取，避免和 takeSnapshot 交错
+	n.snapMtx.RLock()
+	n.mtx.Lock()
+	index, snapTerm := n.snapshotIndex(), n.log[0].Term
+	n.mtx.Unlock()
+	files, err := openSnapshotFiles(n.snapshotPath())
+	n.snapMtx.RUnlock()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer closeSnapshotFiles(files)
+
+	var chunk uint64
+	send := func(name string, data []byte, done bool) (*InstallSnapshotResponse, error) {
+		n.mtx.Lock()
+		ok := n.state == Leader && n.currentTerm == term && !n.stopped()
+		n.mtx.Unlock()
+		if !ok {
+			return nil, ErrNotLeader
+		}
+		req := &InstallSnapshotRequest{
+			Term:              term,
+			LeaderID:          n.cfg.ID,
+			LastIncludedIndex: index,
+			LastIncludedTerm:  snapTerm,
+			Chunk:             chunk,
+			FileName:          name,
+			Data:              data,
+			Done:              done,
+		}
+		chunk++
+		return n.cfg.Transport.InstallSnapshot(context.Background(), peer, req)
+	}
+
+	// 快照目录为空时也需要发送一块，follower 据此安装空的状态机
+	if len(files) == 0 {
+		resp, err := send("", nil, true)
+		return index, resp, err
+	}
+
+	buf := make([]byte, snapshotChunkSize)
+	for i, f := range files {
+		for offset := int64(0); ; {
+			size := min(snapshotChunkSize, f.size-offset)
+			if _, err := io.ReadFull(f.file, buf[:size]); err != nil {
+				return 0, nil, err
+			}
+			offset += size
+			done := i == len(files)-1 && offset >= f.size
+			resp, err := send(f.name, buf[:size], done)
+			if err != nil || done || resp.Term > term || !resp.Success {
+				return index, resp, err
+			}
+			if offset >= f.size {
+				break
+			}
+		}
+	}
+	return index, nil, errSnapshotChunkMismatch
+}
+
+// 正在接收的快照，各个块按照顺序写入临时目录，接收完整之后再安装
+type snapshotReceiver struct {
+	mtx     *sync.Mutex
+	dirPath string
+	index   uint64
+	term    uint64
+	next    uint64 // 下一个期望收到的块
+	name    string
+	file    *os.File
+}
+
+func newSnapshotReceiver(dirPath string) *snapshotReceiver {
+	return &snapshotReceiver{mtx: new(sync.Mutex), dirPath: dirPath}
+}
+
+// 写入收到的块，返回快照是否已经接收完整
+// 在访问此方法前必须持有 receiver 的互斥锁
+func (r *snapshotReceiver) receive(req *InstallSnapshotRequest) (bool, error) {
+	if req.Chunk == 0 {
+		// 新的快照，丢弃之前没有接收完整的快照
+		r.reset()
+		if err := os.RemoveAll(r.dirPath); err != nil {
+			return false, err
+		}
+		if err := os.MkdirAll(r.dirPath, os.ModePerm); err != nil {
+			return false, err
+		}
+		r.index, r.term = req.LastIncludedIndex, req.LastIncludedTerm
+	} else if req.LastIncludedIndex != r.index || req.LastIncludedTerm != r.term || req.Chunk != r.next {
+		return false, errSnapshotChunkMismatch
+	}
+
+	if req.FileName != "" {
+		if r.file == nil || r.name != req.FileName {
+			if err := r.closeFile(); err != nil {
+				return false, err
+			}
+			file, err := os.OpenFile(filepath.Join(r.dirPath, filepath.Base(req.FileName)),
+				os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			if err != nil {
+				return false, err
+			}
+			r.name, r.file = req.FileName, file
+		}
+		if _, err := r.file.Write(req.Data); err != nil {
+			return false, err
+		}
+	}
+	r.next++
+
+	if !req.Done {
+		return false, nil
+	}
+	if err := r.closeFile(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *snapshotReceiver) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file, r.name = nil, ""
+	return err
+}
+
+// 丢弃正在接收的快照，之后只接受新快照的第一块
+func (r *snapshotReceiver) reset() {
+	_ = r.closeFile()
+	r.index, r.term, r.next = 0, 0, 0
+}
+
+// 安装接收完整的快照，替换状态机的数据目录
+// 在访问此方法前必须持有状态机的写锁
+func (n *Node) installSnapshot(recvPath string) error {
+	n.snapMtx.Lock()
+	defer n.snapMtx.Unlock()
+	if err := replaceDir(recvPath, n.snapshotPath()); err != nil {
+		return err
+	}
+	if err := n.db.Close(); err != nil {
+		return err
+	}
+	n.db = nil
+	if err := os.RemoveAll(n.dataPath()); err != nil {
+		return err
+	}
+	if err := utils.CopyDir(n.snapshotPath(), n.dataPath(), nil); err != nil {
+		return err
+	}
+	db, err := n.openDB()
+	if err != nil {
+		return err
+	}
+	n.db = db
+	return nil
+}
@@ -0,0 +1,154 @@
+package raft
+
+import (
+	bitcask "bitcask-kv"
+	"encoding/binary"
+	"strconv"
+)
+
+var (
+	termKey          = []byte("term")
+	voteKey          = []byte("vote")
+	snapshotIndexKey = []byte("snapshot-index")
+	snapshotTermKey  = []byte("snapshot-term")
+	logKeyPrefix     = []byte("log/")
+)
+
+// raft 需要持久化的状态，使用一个独立的 bitcask 实例存储
+type storage struct {
+	db *bitcask.DB
+}
+
+func openStorage(dirPath string, syncWrites bool) (*storage, error) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath = dirPath
+	opts.SyncWrites = syncWrites
+	db, err := bitcask.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &storage{db: db}, nil
+}
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(logKeyPrefix)+8)
+	copy(key, logKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(logKeyPrefix):], index)
+	return key
+}
+
+// 日志 key 的上界，不包含
+func logKeyUpperBound() []byte {
+	upper := append([]byte{}, logKeyPrefix...)
+	upper[len(upper)-1]++
+	return upper
+}
+
+func encodeEntry(entry *Entry) []byte {
+	buf := make([]byte, 8+len(entry.Data))
+	binary.BigEndian.PutUint64(buf[:8], entry.Term)
+	copy(buf[8:], entry.Data)
+	return buf
+}
+
+func decodeEntry(index uint64, buf []byte) *Entry {
+	return &Entry{
+		Index: index,
+		Term:  binary.BigEndian.Uint64(buf[:8]),
+		Data:  buf[8:],
+	}
+}
+
+// 持久化当前任期和投票对象
+func (s *storage) saveState(term uint64, votedFor string) error {
+	wb := s.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	_ = wb.Put(termKey, []byte(strconv.FormatUint(term, 10)))
+	_ = wb.Put(voteKey, []byte(votedFor))
+	return wb.Commit()
+}
+
+func (s *storage) loadState() (uint64, string, error) {
+	term, err := s.getUint64(termKey)
+	if err != nil {
+		return 0, "", err
+	}
+	votedFor, err := s.db.Get(voteKey)
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return 0, "", err
+	}
+	return term, string(votedFor), nil
+}
+
+func (s *storage) saveSnapshotMeta(index, term uint64) error {
+	wb := s.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	_ = wb.Put(snapshotIndexKey, []byte(strconv.FormatUint(index, 10)))
+	_ = wb.Put(snapshotTermKey, []byte(strconv.FormatUint(term, 10)))
+	return wb.Commit()
+}
+
+func (s *storage) loadSnapshotMeta() (uint64, uint64, error) {
+	index, err := s.getUint64(snapshotIndexKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	term, err := s.getUint64(snapshotTermKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	return index, term, nil
+}
+
+func (s *storage) getUint64(key []byte) (uint64, error) {
+	value, err := s.db.Get(key)
+	if err == bitcask.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// 追加日志条目
+func (s *storage) appendEntries(entries []*Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.MaxBatchNum = uint(len(entries))
+	wb := s.db.NewWriteBatch(opts)
+	for _, entry := range entries {
+		_ = wb.Put(logKey(entry.Index), encodeEntry(entry))
+	}
+	return wb.Commit()
+}
+
+// 删除 index 及之后的所有日志条目
+func (s *storage) truncateFrom(index uint64) error {
+	return s.db.DeleteRange(logKey(index), logKeyUpperBound())
+}
+
+// 删除 index 及之前的所有日志条目，这些日志已经包含在快照中
+func (s *storage) compactTo(index uint64) error {
+	return s.db.DeleteRange(logKey(0), logKey(index+1))
+}
+
+// 按照顺序加载所有的日志条目
+func (s *storage) loadEntries() ([]*Entry, error) {
+	var entries []*Entry
+	it := s.db.NewIterator(bitcask.IteratorOptions{Prefix: logKeyPrefix})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return nil, err
+		}
+		index := binary.BigEndian.Uint64(it.Key()[len(logKeyPrefix):])
+		entries = append(entries, decodeEntry(index, value))
+	}
+	return entries, nil
+}
+
+func (s *storage) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,174 @@
+package raft
+
+import (
+	"context"
+	"sync"
+)
+
+// Entry raft 日志条目，Data 为空表示 leader 当选时写入的空操作
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+type RequestVoteRequest struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type RequestVoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []*Entry
+	LeaderCommit uint64
+}
+
+type AppendEntriesResponse struct {
+	Term    uint64
+	Success bool
+	// 日志不匹配时，leader 下一次应该从这个位置开始发送
+	ConflictIndex uint64
+}
+
+// InstallSnapshotRequest 发送快照中的一块，快照为状态机数据目录中的所有文件
+// 同一个快照的块按照顺序发送，同一个文件的块是连续的
+type InstallSnapshotRequest struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Chunk             uint64 // 块在快照中的序号，为 0 时表示开始发送新的快照
+	FileName          string
+	Data              []byte
+	Done              bool // 是否是快照的最后一块
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64
+	// 块是否被接收，为 false 时 leader 需要从第一块重新发送
+	Success bool
+}
+
+// RPCHandler 处理其他节点发来的 RPC 请求，由 Node 实现
+type RPCHandler interface {
+	HandleRequestVote(req *RequestVoteRequest) *RequestVoteResponse
+	HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse
+	HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse
+}
+
+// Transport 节点之间通信的传输层
+type Transport interface {
+	// Register 注册本节点处理 RPC 请求的 handler
+	Register(handler RPCHandler)
+
+	RequestVote(ctx context.Context, target string, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+
+	// Close 取消注册，之后其他节点无法再访问本节点
+	Close() error
+}
+
+// InMemNetwork 进程内的网络，用于测试，可以模拟节点之间的网络断开
+type InMemNetwork struct {
+	mtx          *sync.RWMutex
+	handlers     map[string]RPCHandler
+	disconnected map[string]bool
+}
+
+func NewInMemNetwork() *InMemNetwork {
+	return &InMemNetwork{
+		mtx:          new(sync.RWMutex),
+		handlers:     make(map[string]RPCHandler),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport 获取节点 id 对应的传输层
+func (nw *InMemNetwork) Transport(id string) Transport {
+	return &inMemTransport{network: nw, id: id}
+}
+
+// Disconnect 断开节点和其他所有节点之间的网络
+func (nw *InMemNetwork) Disconnect(id string) {
+	nw.mtx.Lock()
+	defer nw.mtx.Unlock()
+	nw.disconnected[id] = true
+}
+
+// Reconnect 恢复节点的网络
+func (nw *InMemNetwork) Reconnect(id string) {
+	nw.mtx.Lock()
+	defer nw.mtx.Unlock()
+	delete(nw.disconnected, id)
+}
+
+func (nw *InMemNetwork) handler(from, to string) (RPCHandler, error) {
+	nw.mtx.RLock()
+	defer nw.mtx.RUnlock()
+	if nw.disconnected[from] || nw.disconnected[to] {
+		return nil, ErrUnreachable
+	}
+	handler, ok := nw.handlers[to]
+	if !ok {
+		return nil, ErrUnreachable
+	}
+	return handler, nil
+}
+
+type inMemTransport struct {
+	network *InMemNetwork
+	id      string
+}
+
+func (t *inMemTransport) Register(handler RPCHandler) {
+	t.network.mtx.Lock()
+	defer t.network.mtx.Unlock()
+	t.network.handlers[t.id] = handler
+}
+
+func (t *inMemTransport) RequestVote(ctx context.Context, target string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleRequestVote(req), nil
+}
+
+func (t *inMemTransport) AppendEntries(ctx context.Context, target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return nil, err
+	}
+	resp := handler.HandleAppendEntries(req)
+	// 响应在返回途中同样可能丢失
+	if _, err := t.network.handler(target, t.id); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *inMemTransport) InstallSnapshot(ctx context.Context, target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleInstallSnapshot(req), nil
+}
+
+func (t *inMemTransport) Close() error {
+	t.network.mtx.Lock()
+	defer t.network.mtx.Unlock()
+	delete(t.network.handlers, t.id)
+	return nil
+}
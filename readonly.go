@@ -0,0 +1,141 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Refresh 加载写入进程在只读实例打开之后追加的数据，包括活跃文件中新写入的记录和新创建的数据文件
+// 只读实例（Options.ReadOnly）不持有文件锁，可以在写入进程运行时打开同一个数据目录，所有的写入都会返回 ErrDatabaseReadOnly，
+// 不进行 merge 和过期清理，关闭时也不会修改数据目录。
+// 只能在只读模式下调用，否则返回 ErrNotReadOnly，时间点恢复打开的实例不能 Refresh。
+// 末尾还没有写完整的记录和还没有提交的事务会在下次 Refresh 时加载。
+// 写入进程在重启时应用 merge 的结果不影响只读实例，已经打开的旧数据文件仍然可以读取，重新打开之后才会使用 merge 之后的数据文件
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return ErrNotReadOnly
+	}
+	if db.options.RecoverUntil != nil {
+		return ErrRefreshRecovering
+	}
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	// 数据库已经关闭
+	select {
+	case <-db.closeChan:
+		return nil
+	default:
+	}
+
+	for {
+		if db.activeFile != nil {
+			if err := db.refreshActiveFile(); err != nil {
+				return err
+			}
+		}
+
+		nextFid, ok, err := db.nextDataFileIdOnDisk()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		// 已经创建了新的数据文件，说明当前文件不会再有写入，再读取一次确保不会遗漏切换之前的写入
+		if db.activeFile != nil {
+			if err := db.refreshActiveFile(); err != nil {
+				return err
+			}
+		}
+
+		dataFile, err := data.OpenDataFile(db.options.DirPath, nextFid, fio.StandardIO)
+		if err != nil {
+			return err
+		}
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+	}
+
+	db.notifyWatchers()
+	return nil
+}
+
+// 从活跃文件中已经加载的位置开始，将写入进程新追加的记录更新到内存索引中
+// 在访问此方法前必须持有互斥锁
+func (db *DB) refreshActiveFile() error {
+	if db.pendingTxnRecords == nil {
+		db.pendingTxnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+	for {
+		offset := db.activeFile.WriteOff
+		logRecord, size, err := db.activeFile.ReadLogRecord(offset)
+		if err != nil {
+			// 已经读取到末尾，或者末尾的记录还没有写完整
+			if err == io.EOF || err == data.ErrInvalidCRC {
+				return nil
+			}
+			return err
+		}
+		pos := &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		db.activeFile.WriteOff += size
+		db.replayLogRecord(logRecord, pos, db.pendingTxnRecords)
+	}
+}
+
+// 查找数据目录中比当前活跃文件 id 更大的下一个数据文件
+func (db *DB) nextDataFileIdOnDisk() (uint32, bool, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var nextFid uint32
+	var found bool
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			return 0, false, ErrDataDirectoryCorrupted
+		}
+		fid := uint32(fileId)
+		if db.activeFile != nil && fid <= db.activeFile.FileId {
+			continue
+		}
+		if !found || fid < nextFid {
+			nextFid, found = fid, true
+		}
+	}
+	return nextFid, found, nil
+}
+
+// 只读实例定期加载写入进程新写入的数据
+func (db *DB) startRefreshCheck() {
+	ticker := time.NewTicker(db.options.ReadOnlyRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 出错时等待下一次重试
+			_ = db.Refresh()
+		case <-db.closeChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 写入进程运行时也可以打开只读实例
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(ro.ListKeys()))
+
+	// 所有的写入都会被拒绝
+	assert.Equal(t, ErrDatabaseReadOnly, ro.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrDatabaseReadOnly, ro.Delete(utils.GetTestKey(1)))
+	wb := ro.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrDatabaseReadOnly, wb.Commit())
+	assert.Equal(t, ErrDatabaseReadOnly, ro.Merge())
+
+	// 写入进程新写入的数据需要 Refresh 之后才能读取，包括切换到新的数据文件
+	for i := 100; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+
+	_, err = ro.Get(utils.GetTestKey(300))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, 400, len(ro.ListKeys()))
+	_, err = ro.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := ro.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+
+	// 只读实例关闭时不会写入事务序列号文件
+	assert.Nil(t, ro.Close())
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// 读写实例不能调用 Refresh
+	assert.Equal(t, ErrNotReadOnly, db.Refresh())
+}
+
+func TestDB_ReadOnlyAutoRefresh(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-refresh")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 数据目录为空时打开只读实例
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.ReadOnlyRefreshInterval = time.Millisecond * 10
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer ro.Close()
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("value")))
+	waitUntil(t, func() bool {
+		val, err := ro.Get(utils.GetTestKey(1))
+		return err == nil && string(val) == "value"
+	})
+
+	// 只读实例不能创建数据目录
+	roOpts.DirPath = filepath.Join(dir, "not-exist")
+	_, err = Open(roOpts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,198 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// 日志记录是否在恢复目标之前提交，旧版本写入的记录没有提交信息，认为在恢复目标之前
+func (p *RecoverPoint) includes(logRecord *data.LogRecord) bool {
+	if logRecord.CommitSeq == 0 {
+		return true
+	}
+	if p.Seq > 0 && logRecord.CommitSeq > p.Seq {
+		return false
+	}
+	if !p.Time.IsZero() && logRecord.CommitTime > p.Time.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// 时间点恢复需要读取的一个数据文件
+type recoverFile struct {
+	fileName string
+	fid      uint32
+}
+
+// 加载时间点恢复需要的数据文件，包括归档目录中 merge 之前的数据文件和数据目录中的数据文件
+// 每次 merge 归档的子目录中包含 id 小于该子目录名称的数据文件，按顺序拼接得到完整的写入历史：
+// 最早的归档中的所有文件，之后每个归档中 id 不小于上一个归档名称的文件，最后是数据目录中 id 不小于最后一个归档名称的文件。
+// 不同目录中的文件 id 可能重复，因此按照顺序重新分配文件 id，只能以只读的方式打开
+func (db *DB) loadRecoverFiles() error {
+	var files []*recoverFile
+
+	var boundary uint32
+	var archived bool
+	if db.options.MergeArchiveDir != "" {
+		boundaries, err := archiveBoundaries(db.options.MergeArchiveDir)
+		if err != nil {
+			return err
+		}
+		for _, b := range boundaries {
+			archiveDir := filepath.Join(db.options.MergeArchiveDir, fmt.Sprintf("%09d", b))
+			archiveFiles, err := listDataFiles(archiveDir)
+			if err != nil {
+				return err
+			}
+			for _, file := range archiveFiles {
+				if !archived || file.fid >= boundary {
+					files = append(files, file)
+				}
+			}
+			boundary, archived = b, true
+		}
+	}
+
+	dataFiles, err := listDataFiles(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range dataFiles {
+		if !archived || file.fid >= boundary {
+			files = append(files, file)
+		}
+	}
+
+	for i, file := range files {
+		dataFile, err := data.OpenDataFileWithName(file.fileName, uint32(i), fio.StandardIO)
+		if err != nil {
+			return err
+		}
+		db.fileIds = append(db.fileIds, i)
+		if i == len(files)-1 {
+			db.activeFile = dataFile
+		} else {
+			db.olderFiles[uint32(i)] = dataFile
+		}
+	}
+	return nil
+}
+
+// 归档目录中的子目录名称，即每次 merge 完成时的文件 id，从小到大排序
+func archiveBoundaries(archiveDir string) ([]uint32, error) {
+	dirEntries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var boundaries []uint32
+	for _, entry := range dirEntries {
+		if !entry.IsDir() {
+			continue
+		}
+		b, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		boundaries = append(boundaries, uint32(b))
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i] < boundaries[j]
+	})
+	return boundaries, nil
+}
+
+// 目录中的数据文件，按文件 id 从小到大排序
+func listDataFiles(dirPath string) ([]*recoverFile, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []*recoverFile
+	for _, entry := range dirEntries {
+		fid, ok := parseDataFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		files = append(files, &recoverFile{fileName: filepath.Join(dirPath, entry.Name()), fid: fid})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].fid < files[j].fid
+	})
+	return files, nil
+}
+
+// 将 merge 之后被替换的旧数据文件移动到归档目录中
+// 旧数据文件在下次启动应用 merge 结果时移动到以 merge 完成时的文件 id 命名的子目录中，需要定期清理不再需要的子目录
+func (db *DB) archiveDataFile(fileName string, nonMergeFileId uint32) error {
+	archiveDir := filepath.Join(db.options.MergeArchiveDir, fmt.Sprintf("%09d", nonMergeFileId))
+	if err := os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+		return err
+	}
+	destName := filepath.Join(archiveDir, filepath.Base(fileName))
+	if err := os.Rename(fileName, destName); err == nil {
+		return nil
+	}
+
+	// 归档目录和数据目录不在同一个文件系统中，拷贝之后删除
+	if _, err := os.Stat(destName); err == nil {
+		if err := os.Remove(destName); err != nil {
+			return err
+		}
+	}
+	if err := utils.CopyFile(fileName, destName); err != nil {
+		return err
+	}
+	return os.Remove(fileName)
+}
+
+// 恢复最近一次提交的全局序列号
+// 从数据文件加载索引时已经读取了没有参与 merge 的数据文件，如果其中没有带提交信息的记录（例如使用 B+ 树索引，
+// 或者所有的数据都已经被 merge 重写），从最新的数据文件开始向前查找。
+// merge 重写的文件中最大的提交序列号保存在 merge 完成标识文件中，之前版本写入的文件中没有保存时才读取这些文件
+func (db *DB) loadCommitSeq() {
+	if db.commitSeq > 0 {
+		return
+	}
+	var mergeCommitSeq uint64
+	var hasMergeCommitSeq bool
+	if db.mergeBoundary > 0 {
+		mergeCommitSeq, hasMergeCommitSeq = getMergeCommitSeq(db.options.DirPath)
+	}
+	for i := len(db.fileIds) - 1; i >= 0; i-- {
+		fid := uint32(db.fileIds[i])
+		if fid < db.mergeBoundary && hasMergeCommitSeq {
+			db.commitSeq = mergeCommitSeq
+			return
+		}
+		dataFile := db.olderFiles[fid]
+		if db.activeFile != nil && fid == db.activeFile.FileId {
+			dataFile = db.activeFile
+		}
+		if dataFile == nil {
+			continue
+		}
+
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				break
+			}
+			db.commitSeq = max(db.commitSeq, logRecord.CommitSeq)
+			offset += size
+		}
+		if db.commitSeq > 0 {
+			return
+		}
+	}
+}
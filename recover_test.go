@@ -0,0 +1,83 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_RecoverUntil(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover")
+	archiveDir, _ := os.MkdirTemp("", "bitcask-go-recover-archive")
+	defer os.RemoveAll(archiveDir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.MergeArchiveDir = archiveDir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("good")))
+	}
+	goodSeq := db.Stat().CommitSeq
+	assert.Equal(t, uint64(100), goodSeq)
+	time.Sleep(time.Millisecond * 10)
+	goodTime := time.Now()
+	time.Sleep(time.Millisecond * 10)
+
+	// 错误的写入
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(256)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), []byte("bad")))
+	assert.Nil(t, wb.Commit())
+
+	// merge 之后重启，被替换的旧数据文件移动到归档目录中
+	assert.Nil(t, db.Merge())
+	lastSeq := db.Stat().CommitSeq
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, lastSeq, db.Stat().CommitSeq)
+	entries, err := os.ReadDir(archiveDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("after merge")))
+	assert.Equal(t, lastSeq+1, db.Stat().CommitSeq)
+
+	check := func(point *RecoverPoint) {
+		recoverOpts := opts
+		recoverOpts.ReadOnly = true
+		recoverOpts.RecoverUntil = point
+		recovered, err := Open(recoverOpts)
+		assert.Nil(t, err)
+		defer recovered.Close()
+
+		assert.Equal(t, 100, len(recovered.ListKeys()))
+		for i := 0; i < 100; i++ {
+			val, err := recovered.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("good"), val)
+		}
+		assert.Equal(t, goodSeq, recovered.Stat().CommitSeq)
+		assert.Equal(t, ErrDatabaseReadOnly, recovered.Put(utils.GetTestKey(1), []byte("value")))
+		assert.Equal(t, ErrRefreshRecovering, recovered.Refresh())
+	}
+	check(&RecoverPoint{Seq: goodSeq})
+	check(&RecoverPoint{Time: goodTime})
+
+	// 时间点恢复必须以只读方式打开
+	recoverOpts := opts
+	recoverOpts.RecoverUntil = &RecoverPoint{Seq: goodSeq}
+	_, err = Open(recoverOpts)
+	assert.NotNil(t, err)
+}
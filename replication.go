@@ -0,0 +1,712 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 复制协议
+// 从节点连接之后发送握手消息：magic(4) | fid(4) | offset(8)，表示从节点已经拥有的数据文件位置。
+// 主节点从该位置开始按顺序发送数据文件中的字节，之后持续发送新追加的日志记录，
+// 每一帧的格式为：type(1) | fid(4) | offset(8) | lag(8) | len(4) | payload，
+// lag 为发送完这一帧之后主节点上剩余未发送的字节数。跨越多个数据文件的事务分为多帧发送，
+// 除最后一帧之外的类型为 replicationFramePartial，从节点收到最后一帧之后才一起写入。
+// 从节点的数据文件和主节点逐字节一致，所以从节点活跃文件的末尾就是下一次复制的起点
+
+const (
+	replicationMagic           = "BKRP"
+	replicationHandshakeSize   = 16
+	replicationFrameHeaderSize = 25
+	maxReplicationChunkSize    = 1024 * 1024
+	replicationWriteTimeout    = 10 * time.Second
+)
+
+// 发送心跳的间隔，测试中可以调小
+var replicationHeartbeatInterval = time.Second
+
+const (
+	replicationFrameData      byte = iota + 1 // 数据文件中的一段字节
+	replicationFrameHeartbeat                 // 心跳，表示已经没有需要发送的数据
+	replicationFrameResync                    // 从节点的数据无法继续复制，需要重新同步
+	replicationFramePartial                   // 跨越数据文件的事务的前一部分，需要和之后的数据帧一起写入
+)
+
+// 复制传输的一段数据
+type replicationChunk struct {
+	fid    uint32
+	offset int64
+	data   []byte
+	lag    int64
+}
+
+// ReplicationServer 复制的主节点服务，向连接的从节点发送数据文件和新写入的日志记录
+type ReplicationServer struct {
+	db       *DB
+	listener net.Listener
+	mtx      *sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       *sync.WaitGroup
+}
+
+// StartReplicationServer 在 addr 上监听从节点的连接，作为复制的主节点
+func (db *DB) StartReplicationServer(addr string) (*ReplicationServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	rs := &ReplicationServer{
+		db:       db,
+		listener: listener,
+		mtx:      new(sync.Mutex),
+		conns:    make(map[net.Conn]struct{}),
+		wg:       new(sync.WaitGroup),
+	}
+	rs.wg.Add(1)
+	go rs.serve()
+	return rs, nil
+}
+
+// Addr 主节点监听的地址
+func (rs *ReplicationServer) Addr() net.Addr {
+	return rs.listener.Addr()
+}
+
+// Close 停止监听，并断开所有从节点的连接
+func (rs *ReplicationServer) Close() error {
+	rs.mtx.Lock()
+	if rs.closed {
+		rs.mtx.Unlock()
+		return nil
+	}
+	rs.closed = true
+	err := rs.listener.Close()
+	for conn := range rs.conns {
+		_ = conn.Close()
+	}
+	rs.mtx.Unlock()
+
+	rs.wg.Wait()
+	return err
+}
+
+func (rs *ReplicationServer) serve() {
+	defer rs.wg.Done()
+	for {
+		conn, err := rs.listener.Accept()
+		if err != nil {
+			return
+		}
+		rs.mtx.Lock()
+		if rs.closed {
+			rs.mtx.Unlock()
+			_ = conn.Close()
+			return
+		}
+		rs.conns[conn] = struct{}{}
+		rs.wg.Add(1)
+		rs.mtx.Unlock()
+
+		go func() {
+			defer rs.wg.Done()
+			_ = rs.serveConn(conn)
+			rs.mtx.Lock()
+			delete(rs.conns, conn)
+			rs.mtx.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// 向一个从节点发送数据，直到连接断开或者数据库关闭
+func (rs *ReplicationServer) serveConn(conn net.Conn) error {
+	handshake := make([]byte, replicationHandshakeSize)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return err
+	}
+	if string(handshake[:4]) != replicationMagic {
+		return errors.New("invalid replication handshake")
+	}
+	fid := binary.BigEndian.Uint32(handshake[4:8])
+	offset := int64(binary.BigEndian.Uint64(handshake[8:16]))
+
+	writer := bufio.NewWriter(conn)
+	if err := rs.db.checkReplicationPos(fid, offset); err != nil {
+		_ = writeReplicationFrame(conn, writer, replicationFrameResync, &replicationChunk{data: []byte(err.Error())})
+		return err
+	}
+
+	sendHeartbeat := true
+	for {
+		chunks, notify, err := rs.db.readReplicationChunks(fid, offset)
+		if err != nil {
+			return err
+		}
+		if len(chunks) > 0 {
+			for i, chunk := range chunks {
+				typ := replicationFrameData
+				if i < len(chunks)-1 {
+					typ = replicationFramePartial
+				}
+				if err := writeReplicationFrame(conn, writer, typ, chunk); err != nil {
+					return err
+				}
+				fid, offset = chunk.fid, chunk.offset+int64(len(chunk.data))
+			}
+			continue
+		}
+
+		// 已经发送了所有的数据，等待新的写入，空闲时发送心跳
+		if sendHeartbeat {
+			heartbeat := &replicationChunk{fid: fid, offset: offset}
+			if err := writeReplicationFrame(conn, writer, replicationFrameHeartbeat, heartbeat); err != nil {
+				return err
+			}
+		}
+		timer := time.NewTimer(replicationHeartbeatInterval)
+		select {
+		case <-notify:
+			sendHeartbeat = false
+		case <-timer.C:
+			sendHeartbeat = true
+		case <-rs.db.closeChan:
+			timer.Stop()
+			return nil
+		}
+		timer.Stop()
+	}
+}
+
+// 校验从节点的复制起点是否有效
+func (db *DB) checkReplicationPos(fid uint32, offset int64) error {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	// 从头开始复制总是有效的
+	if fid == 0 && offset == 0 {
+		return nil
+	}
+	// 从节点的数据文件已经被主节点 merge 重写
+	if fid < db.mergeBoundary {
+		return ErrReplicationResync
+	}
+	// 从节点的数据比主节点更多，两者已经不一致
+	if db.activeFile == nil || fid > db.activeFile.FileId ||
+		(fid == db.activeFile.FileId && offset > db.activeFile.WriteOff) {
+		return ErrReplicationResync
+	}
+	return nil
+}
+
+// 读取从 (fid, offset) 开始需要发送给从节点的数据
+// 数据只在事务的边界处切分，跨越数据文件的事务返回多个文件中的数据，从节点任何时候拥有的都是完整提交的数据。
+// 已经发送完所有数据时返回一个在下次写入时关闭的通道
+func (db *DB) readReplicationChunks(fid uint32, offset int64) ([]*replicationChunk, <-chan struct{}, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	select {
+	case <-db.closeChan:
+		return nil, nil, io.EOF
+	default:
+	}
+
+	var chunks []*replicationChunk
+	openTxn := nonTransactionSeqNo
+	for {
+		if db.activeFile == nil {
+			return nil, db.watchNotifyChan(), nil
+		}
+
+		dataFile, limit, err := db.replicationFile(fid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dataFile == nil || offset >= limit {
+			// 活跃文件的末尾还有没有完成的事务，只能是崩溃时没有写完的事务，等待之后的写入
+			if dataFile == db.activeFile {
+				return nil, db.watchNotifyChan(), nil
+			}
+			// 当前文件已经发送完毕，切换到下一个数据文件
+			next, ok := db.nextDataFileId(fid)
+			if !ok {
+				return nil, db.watchNotifyChan(), nil
+			}
+			fid, offset = next, 0
+			continue
+		}
+
+		buf, txn, err := readCommittedBytes(dataFile, offset, limit, openTxn, dataFile != db.activeFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(buf) > 0 {
+			chunks = append(chunks, &replicationChunk{fid: fid, offset: offset, data: buf})
+		}
+		openTxn = txn
+		if openTxn == nonTransactionSeqNo {
+			break
+		}
+		// 事务在下一个数据文件中继续
+		offset += int64(len(buf))
+	}
+
+	last := chunks[len(chunks)-1]
+	lag, err := db.replicationLag(last.fid, last.offset+int64(len(last.data)))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, chunk := range chunks {
+		chunk.lag = lag
+	}
+	return chunks, nil, nil
+}
+
+// 获取复制的数据文件和可以读取的范围，文件不存在时返回 nil
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) replicationFile(fid uint32) (*data.DataFile, int64, error) {
+	if fid == db.activeFile.FileId {
+		return db.activeFile, db.activeFile.WriteOff, nil
+	}
+	dataFile := db.olderFiles[fid]
+	if dataFile == nil {
+		return nil, 0, nil
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	return dataFile, size, nil
+}
+
+// 从数据文件中读取从 offset 开始的一段字节，结尾处没有未完成的事务
+// openTxn 为之前的文件末尾还没有完成的事务，单个事务超过块大小时会读取整个事务。
+// 读取到 limit 时仍然有没有完成的事务，返回到 limit 为止的所有字节和这个事务的序列号，事务在下一个数据文件中继续。
+// 事务提交时持有互斥锁，同一个事务的记录是连续的，出现其他记录说明之前的事务因为崩溃不会再完成。
+// 使用 RecoverySkipCorrupted 打开时旧数据文件中可能留有损坏的数据，损坏的数据和之前的事务一起作为完整的一段原样发送，
+// 从节点的数据文件仍然和主节点逐字节一致，从节点更新索引时同样跳过这段数据。sealed 表示数据文件不会再有写入，
+// 末尾不完整的记录也是损坏的数据
+func readCommittedBytes(dataFile *data.DataFile, offset int64, limit int64, openTxn uint64, sealed bool) ([]byte, uint64, error) {
+	size := int64(maxReplicationChunkSize)
+	for {
+		if offset+size > limit {
+			size = limit - offset
+		}
+		buf, err := dataFile.ReadBytes(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// 找到最后一个没有未完成事务的位置
+		var pos, cut int64
+		txn := openTxn
+		for pos < size {
+			logRecord, n, err := data.DecodeLogRecord(buf[pos:])
+			if err == io.ErrUnexpectedEOF && (!sealed || offset+size < limit) {
+				break
+			}
+			if err != nil {
+				// 跳过损坏的数据，找到下一条有效的记录，损坏数据中的事务不会再完成
+				next, err := findNextLogRecord(dataFile, offset+pos+1, limit)
+				if err != nil {
+					return nil, 0, err
+				}
+				if next < 0 {
+					next = limit
+				}
+				if next-offset > size {
+					break
+				}
+				pos = next - offset
+				txn, cut = nonTransactionSeqNo, pos
+				continue
+			}
+			_, seqNo := parseLogRecordKey(logRecord.Key)
+			if txn != nonTransactionSeqNo && seqNo != txn {
+				txn, cut = nonTransactionSeqNo, pos
+			}
+			pos += n
+			if seqNo != nonTransactionSeqNo {
+				txn = seqNo
+				if logRecord.Type == data.LogRecordTxnFinished {
+					txn = nonTransactionSeqNo
+				}
+			}
+			if txn == nonTransactionSeqNo {
+				cut = pos
+			}
+		}
+
+		if offset+size == limit {
+			if txn == nonTransactionSeqNo {
+				return buf, nonTransactionSeqNo, nil
+			}
+			if cut > 0 {
+				return buf[:cut], nonTransactionSeqNo, nil
+			}
+			// 事务跨越了数据文件
+			return buf, txn, nil
+		}
+		if cut > 0 {
+			return buf[:cut], nonTransactionSeqNo, nil
+		}
+		size *= 2
+	}
+}
+
+// 计算主节点上 (fid, offset) 之后还有多少字节
+// 在访问此方法前必须持有读锁或互斥锁
+func (db *DB) replicationLag(fid uint32, offset int64) (int64, error) {
+	lag := -offset
+	for id, dataFile := range db.olderFiles {
+		if id < fid {
+			continue
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		lag += size
+	}
+	if db.activeFile.FileId >= fid {
+		lag += db.activeFile.WriteOff
+	}
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, nil
+}
+
+func writeReplicationFrame(conn net.Conn, writer *bufio.Writer, typ byte, chunk *replicationChunk) error {
+	header := make([]byte, replicationFrameHeaderSize)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:5], chunk.fid)
+	binary.BigEndian.PutUint64(header[5:13], uint64(chunk.offset))
+	binary.BigEndian.PutUint64(header[13:21], uint64(chunk.lag))
+	binary.BigEndian.PutUint32(header[21:25], uint32(len(chunk.data)))
+
+	if err := conn.SetWriteDeadline(time.Now().Add(replicationWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := writer.Write(chunk.data); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func readReplicationFrame(reader *bufio.Reader) (byte, *replicationChunk, error) {
+	header := make([]byte, replicationFrameHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	chunk := &replicationChunk{
+		fid:    binary.BigEndian.Uint32(header[1:5]),
+		offset: int64(binary.BigEndian.Uint64(header[5:13])),
+		lag:    int64(binary.BigEndian.Uint64(header[13:21])),
+		data:   make([]byte, binary.BigEndian.Uint32(header[21:25])),
+	}
+	if _, err := io.ReadFull(reader, chunk.data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], chunk, nil
+}
+
+// ReplicationStat 从节点的复制状态
+type ReplicationStat struct {
+	Connected   bool      // 是否和主节点保持连接
+	Fid         uint32    // 已经复制到的数据文件 id
+	Offset      int64     // 已经复制到的数据文件偏移
+	LagBytes    int64     // 主节点上还未复制的字节数
+	LastContact time.Time // 最近一次收到主节点消息的时间
+	LastError   error     // 最近一次复制出错的原因
+	Promoted    bool      // 是否已经提升为主节点
+}
+
+// Follower 复制的从节点，持续从主节点复制数据，数据库在提升为主节点之前是只读的
+type Follower struct {
+	db       *DB
+	options  FollowerOptions
+	mtx      *sync.Mutex
+	stat     ReplicationStat
+	stopChan chan struct{}
+	doneChan chan struct{}
+	// 以下字段只在复制协程中访问，并且需要持有 DB 的互斥锁
+	indexOff   int64                                // 活跃文件中已经更新到索引的位置
+	txnRecords map[uint64][]*data.TransactionRecord // 暂存还没有提交的事务数据
+}
+
+// OpenFollower 以从节点的方式打开数据库，并开始从主节点复制数据
+func OpenFollower(options Options, followerOpts FollowerOptions) (*Follower, error) {
+	options.replica = true
+	db, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Follower{
+		db:         db,
+		options:    followerOpts,
+		mtx:        new(sync.Mutex),
+		stopChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+		txnRecords: make(map[uint64][]*data.TransactionRecord),
+	}
+	// 崩溃时可能只写入了跨越数据文件的事务的前一部分，保留这些记录等待之后复制的事务完成记录
+	if db.pendingTxnRecords != nil {
+		f.txnRecords, db.pendingTxnRecords = db.pendingTxnRecords, nil
+	}
+	if db.activeFile != nil {
+		f.indexOff = db.activeFile.WriteOff
+		f.stat.Fid, f.stat.Offset = db.activeFile.FileId, db.activeFile.WriteOff
+	}
+	go f.run()
+	return f, nil
+}
+
+// DB 从节点的数据库实例，提升为主节点之前所有的写入都会返回 ErrReplicaReadOnly
+func (f *Follower) DB() *DB {
+	return f.db
+}
+
+// Stat 从节点的复制状态
+func (f *Follower) Stat() ReplicationStat {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.stat
+}
+
+// Promote 停止复制，将从节点提升为可以写入的主节点
+func (f *Follower) Promote() error {
+	f.stop()
+
+	db := f.db
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if !db.isReplica {
+		return nil
+	}
+	db.isReplica = false
+
+	// 重新构建过期队列，并启动后台任务
+	db.expireQueue = newExpireQueue()
+	db.loadExpireQueue()
+	go db.startMergeCheck()
+	go db.startExpireCheck()
+
+	f.mtx.Lock()
+	f.stat.Connected = false
+	f.stat.Promoted = true
+	f.mtx.Unlock()
+	return nil
+}
+
+// Close 停止复制并关闭数据库
+func (f *Follower) Close() error {
+	f.stop()
+	return f.db.Close()
+}
+
+func (f *Follower) stop() {
+	select {
+	case <-f.stopChan:
+	default:
+		close(f.stopChan)
+	}
+	<-f.doneChan
+}
+
+// 持续复制，连接断开之后自动重连，需要重新同步时停止复制
+func (f *Follower) run() {
+	defer close(f.doneChan)
+	for {
+		err := f.replicate()
+		f.mtx.Lock()
+		f.stat.Connected = false
+		if err != nil {
+			f.stat.LastError = err
+		}
+		f.mtx.Unlock()
+		if err == ErrReplicationResync {
+			return
+		}
+
+		select {
+		case <-f.stopChan:
+			return
+		case <-time.After(f.options.RetryInterval):
+		}
+	}
+}
+
+// 连接主节点并持续应用收到的数据，直到连接断开
+func (f *Follower) replicate() error {
+	conn, err := net.DialTimeout("tcp", f.options.PrimaryAddr, f.options.DialTimeout)
+	if err != nil {
+		return err
+	}
+	connDone := make(chan struct{})
+	defer close(connDone)
+	go func() {
+		select {
+		case <-f.stopChan:
+		case <-connDone:
+		}
+		_ = conn.Close()
+	}()
+
+	f.db.mtx.RLock()
+	var fid uint32
+	var offset int64
+	if f.db.activeFile != nil {
+		fid, offset = f.db.activeFile.FileId, f.db.activeFile.WriteOff
+	}
+	f.db.mtx.RUnlock()
+
+	handshake := make([]byte, replicationHandshakeSize)
+	copy(handshake[:4], replicationMagic)
+	binary.BigEndian.PutUint32(handshake[4:8], fid)
+	binary.BigEndian.PutUint64(handshake[8:16], uint64(offset))
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	// 跨越数据文件的事务中还没有写入的部分，连接断开时丢弃，重连之后主节点会重新发送
+	var partial []*replicationChunk
+	for {
+		// 超过多个心跳间隔没有收到消息，认为连接已经断开
+		if err := conn.SetReadDeadline(time.Now().Add(3 * replicationHeartbeatInterval)); err != nil {
+			return err
+		}
+		typ, chunk, err := readReplicationFrame(reader)
+		if err != nil {
+			select {
+			case <-f.stopChan:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		switch typ {
+		case replicationFramePartial:
+			partial = append(partial, chunk)
+			continue
+		case replicationFrameData:
+			if err := f.apply(append(partial, chunk)); err != nil {
+				return err
+			}
+			partial = nil
+		case replicationFrameHeartbeat:
+		case replicationFrameResync:
+			return ErrReplicationResync
+		default:
+			return fmt.Errorf("unknown replication frame type %d", typ)
+		}
+
+		f.mtx.Lock()
+		f.stat.Connected = true
+		f.stat.LastContact = time.Now()
+		f.stat.LastError = nil
+		f.stat.LagBytes = chunk.lag
+		if typ == replicationFrameData {
+			f.stat.Fid, f.stat.Offset = chunk.fid, chunk.offset+int64(len(chunk.data))
+		}
+		f.mtx.Unlock()
+	}
+}
+
+// 将主节点的数据写入到本地相同的数据文件中，并更新内存索引
+// 跨越数据文件的事务的多段数据在同一次加锁中写入
+func (f *Follower) apply(chunks []*replicationChunk) error {
+	f.db.mtx.Lock()
+	defer f.db.mtx.Unlock()
+	for _, chunk := range chunks {
+		if err := f.applyChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在访问此方法前必须持有 DB 的互斥锁
+func (f *Follower) applyChunk(chunk *replicationChunk) error {
+	db := f.db
+	// 主节点切换到了新的数据文件
+	if db.activeFile == nil || chunk.fid > db.activeFile.FileId {
+		if chunk.offset != 0 {
+			return ErrReplicationResync
+		}
+		if db.activeFile != nil {
+			if err := db.activeFile.Sync(); err != nil {
+				return err
+			}
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, chunk.fid, fio.StandardIO)
+		if err != nil {
+			return err
+		}
+		db.activeFile = dataFile
+		f.indexOff = 0
+	}
+	if chunk.fid != db.activeFile.FileId || chunk.offset != db.activeFile.WriteOff {
+		return ErrReplicationResync
+	}
+
+	if err := db.activeFile.Write(chunk.data); err != nil {
+		return err
+	}
+	if db.options.SyncWrites {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	return f.applyIndex()
+}
+
+// 将新写入的日志记录更新到内存索引中
+// 主节点只发送完整的记录，无法解析的只能是主节点数据文件中损坏的数据，和主节点一样跳到下一条有效的记录
+// 在访问此方法前必须持有 DB 的互斥锁
+func (f *Follower) applyIndex() error {
+	db := f.db
+	for f.indexOff < db.activeFile.WriteOff {
+		logRecord, size, err := db.activeFile.ReadLogRecord(f.indexOff)
+		if err != nil && err != io.EOF && err != data.ErrInvalidCRC {
+			return err
+		}
+		if err != nil {
+			next, err := findNextLogRecord(db.activeFile, f.indexOff+1, db.activeFile.WriteOff)
+			if err != nil {
+				return err
+			}
+			if next < 0 {
+				next = db.activeFile.WriteOff
+			}
+			f.indexOff = next
+			continue
+		}
+		pos := &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: f.indexOff,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		f.indexOff += size
+		db.replayLogRecord(logRecord, pos, f.txnRecords)
+	}
+
+	db.notifyWatchers()
+	return nil
+}
@@ -0,0 +1,293 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"bitcask-kv/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitUntil(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-primary")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	primary, err := Open(opts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+
+	// 复制开始之前已有的数据
+	for i := 0; i < 1000; i++ {
+		err := primary.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	server, err := primary.StartReplicationServer("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-follower")
+	followerOpts.DirPath = followerDir
+	followerOpts.DataFileSize = opts.DataFileSize
+	replOpts := DefaultFollowerOptions
+	replOpts.PrimaryAddr = server.Addr().String()
+	replOpts.RetryInterval = time.Millisecond * 50
+
+	follower, err := OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return len(follower.DB().ListKeys()) == 1000
+	})
+
+	// 复制新的写入
+	err = primary.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	wb := primary.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("batch-1"), []byte("v1"))
+	_ = wb.Put([]byte("batch-2"), []byte("v2"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	for i := 1000; i < 2000; i++ {
+		err := primary.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	waitUntil(t, func() bool {
+		_, err := follower.DB().Get(utils.GetTestKey(1999))
+		return err == nil
+	})
+	for i := 1; i < 2000; i++ {
+		expected, _ := primary.Get(utils.GetTestKey(i))
+		val, err := follower.DB().Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+	_, err = follower.DB().Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := follower.DB().Get([]byte("batch-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	stat := follower.Stat()
+	assert.True(t, stat.Connected)
+	assert.Equal(t, int64(0), stat.LagBytes)
+	assert.Equal(t, primary.activeFile.FileId, stat.Fid)
+	assert.Equal(t, primary.activeFile.WriteOff, stat.Offset)
+
+	// 从节点是只读的
+	err = follower.DB().Put([]byte("key"), []byte("value"))
+	assert.Equal(t, ErrReplicaReadOnly, err)
+	err = follower.DB().Delete(utils.GetTestKey(1))
+	assert.Equal(t, ErrReplicaReadOnly, err)
+	wb = follower.DB().NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("key"), []byte("value"))
+	assert.Equal(t, ErrReplicaReadOnly, wb.Commit())
+	assert.Equal(t, ErrReplicaReadOnly, follower.DB().Merge())
+
+	// 重启从节点之后从中断的位置继续复制
+	err = follower.Close()
+	assert.Nil(t, err)
+	for i := 2000; i < 2500; i++ {
+		err := primary.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	follower, err = OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer destroyDB(follower.DB())
+	waitUntil(t, func() bool {
+		return len(follower.DB().ListKeys()) == 2501
+	})
+
+	// 提升为主节点之后可以写入
+	err = follower.Promote()
+	assert.Nil(t, err)
+	assert.True(t, follower.Stat().Promoted)
+	err = follower.DB().Put([]byte("key"), []byte("value"))
+	assert.Nil(t, err)
+	val, err = follower.DB().Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 主节点新的写入不再复制
+	err = primary.Put([]byte("after-promote"), []byte("value"))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 100)
+	_, err = follower.DB().Get([]byte("after-promote"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestReplication_Resync(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-resync")
+	opts.DirPath = dir
+	primary, err := Open(opts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+	err = primary.Put([]byte("key"), []byte("value"))
+	assert.Nil(t, err)
+
+	server, err := primary.StartReplicationServer("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	// 从节点拥有主节点没有的数据
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-resync-follower")
+	followerOpts.DirPath = followerDir
+	db, err := Open(followerOpts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	replOpts := DefaultFollowerOptions
+	replOpts.PrimaryAddr = server.Addr().String()
+	follower, err := OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer destroyDB(follower.DB())
+	waitUntil(t, func() bool {
+		return follower.Stat().LastError == ErrReplicationResync
+	})
+}
+
+func TestReplication_TxnAcrossFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-txn")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	primary, err := Open(opts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+
+	assert.Nil(t, primary.Put([]byte("key"), utils.RandomValue(3*1024)))
+	// 批量写入跨越了两个数据文件
+	wb := primary.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint32(1), primary.activeFile.FileId)
+
+	// 第一个文件末尾没有完成的事务和下一个文件中的剩余部分一起发送
+	chunks, _, err := primary.readReplicationChunks(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(chunks))
+	end := int64(len(chunks[0].data))
+	chunks, _, err = primary.readReplicationChunks(0, end)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(chunks))
+	assert.Equal(t, primary.olderFiles[0].WriteOff, chunks[0].offset+int64(len(chunks[0].data)))
+	assert.Equal(t, uint32(1), chunks[1].fid)
+	assert.Equal(t, primary.activeFile.WriteOff, int64(len(chunks[1].data)))
+
+	server, err := primary.StartReplicationServer("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	// 从节点在事务的中间重启，只拥有第一个文件中事务的前一部分
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-txn-follower")
+	followerOpts.DirPath = followerDir
+	followerOpts.DataFileSize = opts.DataFileSize
+	buf, err := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(followerDir, 0), buf, fio.DataFilePerm))
+
+	replOpts := DefaultFollowerOptions
+	replOpts.PrimaryAddr = server.Addr().String()
+	follower, err := OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer destroyDB(follower.DB())
+	waitUntil(t, func() bool {
+		return len(follower.DB().ListKeys()) == 21
+	})
+	for i := 0; i < 20; i++ {
+		expected, _ := primary.Get(utils.GetTestKey(i))
+		val, err := follower.DB().Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestReplication_SkipCorrupted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	primary, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, primary.Close())
+
+	// 旧数据文件中间的数据损坏，末尾还有一条不完整的记录
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted data"), 1000)
+	assert.Nil(t, err)
+	info, err := file.Stat()
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0x01, 0x02, 0x03}, info.Size())
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	opts.RecoveryPolicy = RecoverySkipCorrupted
+	primary, err = Open(opts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(primary.RecoveryReport().CorruptedRegions))
+
+	server, err := primary.StartReplicationServer("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	followerOpts := opts
+	followerOpts.DirPath, _ = os.MkdirTemp("", "bitcask-go-replication-corrupted-follower")
+	replOpts := DefaultFollowerOptions
+	replOpts.PrimaryAddr = server.Addr().String()
+	replOpts.RetryInterval = time.Millisecond * 50
+	follower, err := OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+
+	// 损坏的数据不影响之后数据的复制
+	assert.Nil(t, primary.Put([]byte("after"), []byte("value")))
+	waitUntil(t, func() bool {
+		_, err := follower.DB().Get([]byte("after"))
+		return err == nil
+	})
+	keys := primary.ListKeys()
+	assert.Equal(t, len(keys), len(follower.DB().ListKeys()))
+	for _, key := range keys {
+		expected, _ := primary.Get(key)
+		val, err := follower.DB().Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+	stat := follower.Stat()
+	assert.Equal(t, primary.activeFile.FileId, stat.Fid)
+	assert.Equal(t, primary.activeFile.WriteOff, stat.Offset)
+
+	// 从节点重启之后同样跳过损坏的数据
+	assert.Nil(t, follower.Close())
+	follower, err = OpenFollower(followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer destroyDB(follower.DB())
+	assert.Equal(t, len(keys), len(follower.DB().ListKeys()))
+}
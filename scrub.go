@@ -0,0 +1,192 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"io"
+	"sort"
+	"time"
+)
+
+// 后台校验每次持有锁时最多读取的字节数
+const scrubChunkSize = 256 * 1024
+
+// Scrub 从头到尾读取所有的旧数据文件并校验每条记录的 CRC，发现损坏的数据时调用 Options.OnScrubCorruption
+// 旧数据文件不会再被修改，只有在从磁盘读取时才能发现其中的数据损坏，后台校验按照 Options.ScrubInterval 定期调用。
+// 读取速度受 Options.ScrubBytesPerSecond 限制，每次只在读取一小段数据时持有读锁，校验的进度可以通过 Stat 获取。
+// Options.OnScrubCorruption 可以用于从副本恢复损坏的数据，在校验的协程中调用，调用时不持有数据库的锁
+func (db *DB) Scrub() error {
+	db.mtx.Lock()
+	if db.isScrubbing {
+		db.mtx.Unlock()
+		return ErrScrubIsProgress
+	}
+	db.isScrubbing = true
+	fileIds := make([]uint32, 0, len(db.olderFiles))
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	db.mtx.Unlock()
+
+	defer func() {
+		db.mtx.Lock()
+		db.isScrubbing = false
+		db.mtx.Unlock()
+	}()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	s := &scrubber{db: db, start: time.Now()}
+	var totalBytes int64
+	for _, fid := range fileIds {
+		size, ok, err := s.fileSize(fid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			totalBytes += size
+		}
+	}
+	db.scrubbedBytes.Store(0)
+	db.scrubTotalBytes.Store(totalBytes)
+
+	for _, fid := range fileIds {
+		if err := s.scrubFile(fid); err != nil {
+			return err
+		}
+		if s.closed() {
+			return nil
+		}
+	}
+	db.scrubPasses.Add(1)
+	return nil
+}
+
+// 后台定期校验旧数据文件
+func (db *DB) startScrubCheck() {
+	ticker := time.NewTicker(db.options.ScrubInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 出错时等待下一次重试
+			_ = db.Scrub()
+		case <-db.closeChan:
+			return
+		}
+	}
+}
+
+type scrubber struct {
+	db    *DB
+	start time.Time // 本轮校验开始的时间，用于限制读取速度
+	read  int64     // 本轮校验已经读取的字节数
+}
+
+// 数据库是否已经关闭
+func (s *scrubber) closed() bool {
+	select {
+	case <-s.db.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *scrubber) fileSize(fid uint32) (int64, bool, error) {
+	s.db.mtx.RLock()
+	defer s.db.mtx.RUnlock()
+	dataFile, ok := s.db.olderFiles[fid]
+	if !ok {
+		return 0, false, nil
+	}
+	size, err := dataFile.IoManager.Size()
+	return size, true, err
+}
+
+// 顺序校验一个旧数据文件，每次读取一小段数据之后释放锁，避免长时间阻塞写入
+func (s *scrubber) scrubFile(fid uint32) error {
+	fileSize, ok, err := s.fileSize(fid)
+	if err != nil || !ok {
+		return err
+	}
+
+	var offset int64
+	for offset < fileSize {
+		region, next, err := s.scrubChunk(fid, offset, fileSize)
+		if err != nil {
+			return err
+		}
+		if s.closed() {
+			return nil
+		}
+		if region != nil {
+			s.db.scrubCorruptions.Add(1)
+			if s.db.options.OnScrubCorruption != nil {
+				s.db.options.OnScrubCorruption(*region)
+			}
+		}
+		s.db.scrubbedBytes.Add(next - offset)
+		s.read += next - offset
+		offset = next
+		if !s.throttle() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// 从 offset 开始校验最多 scrubChunkSize 字节的记录，返回发现的损坏数据和下一次校验的位置
+func (s *scrubber) scrubChunk(fid uint32, offset int64, fileSize int64) (*CorruptedRegion, int64, error) {
+	s.db.mtx.RLock()
+	defer s.db.mtx.RUnlock()
+	if s.closed() {
+		return nil, fileSize, nil
+	}
+	dataFile := s.db.olderFiles[fid]
+
+	end := offset + scrubChunkSize
+	for offset < fileSize && offset < end {
+		_, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			offset += size
+			continue
+		}
+		if err != io.EOF && err != data.ErrInvalidCRC {
+			return nil, 0, err
+		}
+
+		// 从下一条有效的记录继续校验
+		next, err := findNextLogRecord(dataFile, offset+1, fileSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		if next < 0 {
+			next = fileSize
+		}
+		return &CorruptedRegion{Fid: fid, Offset: offset, Size: next - offset}, next, nil
+	}
+	return nil, offset, nil
+}
+
+// 按照配置的速度限制等待，数据库关闭时返回 false
+func (s *scrubber) throttle() bool {
+	rate := s.db.options.ScrubBytesPerSecond
+	if rate <= 0 {
+		return true
+	}
+	expected := time.Duration(float64(s.read) / float64(rate) * float64(time.Second))
+	wait := expected - time.Since(s.start)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.db.closeChan:
+		return false
+	}
+}
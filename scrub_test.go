@@ -0,0 +1,83 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Scrub(t *testing.T) {
+	var mtx sync.Mutex
+	var regions []CorruptedRegion
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-scrub")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.ScrubBytesPerSecond = 300 * 1024
+	opts.OnScrubCorruption = func(region CorruptedRegion) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		regions = append(regions, region)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	start := time.Now()
+	assert.Nil(t, db.Scrub())
+	stat := db.Stat()
+	assert.Equal(t, uint64(1), stat.ScrubPasses)
+	assert.Equal(t, uint64(0), stat.ScrubErrors)
+	assert.True(t, stat.ScrubTotalBytes > 0)
+	assert.Equal(t, stat.ScrubTotalBytes, stat.ScrubbedBytes)
+	// 读取速度受到限制
+	minDuration := time.Duration(float64(stat.ScrubTotalBytes) / float64(opts.ScrubBytesPerSecond) * float64(time.Second))
+	assert.True(t, time.Since(start) >= minDuration*9/10)
+	assert.Equal(t, 0, len(regions))
+
+	// 旧数据文件中的数据损坏
+	file, err := os.OpenFile(data.GetDataFileName(dir, 1), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("bit rot"), 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	assert.Nil(t, db.Scrub())
+	stat = db.Stat()
+	assert.Equal(t, uint64(2), stat.ScrubPasses)
+	assert.Equal(t, uint64(1), stat.ScrubErrors)
+	assert.Equal(t, stat.ScrubTotalBytes, stat.ScrubbedBytes)
+	assert.Equal(t, 1, len(regions))
+	assert.Equal(t, uint32(1), regions[0].Fid)
+	assert.True(t, regions[0].Offset <= 1000 && regions[0].Offset+regions[0].Size > 1000)
+}
+
+func TestDB_ScrubInBackground(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-scrub-background")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.ScrubInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Eventually(t, func() bool {
+		stat := db.Stat()
+		return stat.ScrubPasses > 0 && stat.ScrubTotalBytes > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(0), db.Stat().ScrubErrors)
+}
@@ -0,0 +1,134 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/index"
+	"sync"
+)
+
+// Snapshot 数据库某一时刻的只读快照
+// 快照持有创建时刻内存索引的副本，之后的写入和删除都不会影响快照读取到的数据。
+// 运行期间的 merge 只写入 merge 目录，旧的数据文件在下次打开时才会被替换，因此快照读取的数据文件在 Close 之前一直有效，
+// 快照不能在 Close 之后继续使用
+type Snapshot struct {
+	db       *DB
+	mtx      *sync.RWMutex
+	seqNo    uint64        // 创建快照时的写入版本号
+	index    index.Indexer // 创建快照时的索引副本
+	released bool
+}
+
+// Snapshot 创建一个当前时刻的只读快照，使用完毕之后需要调用 Release 释放
+func (db *DB) Snapshot() *Snapshot {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.newSnapshot()
+}
+
+// 在访问此方法前必须持有互斥锁
+func (db *DB) newSnapshot() *Snapshot {
+	return &Snapshot{
+		db:    db,
+		mtx:   new(sync.RWMutex),
+		seqNo: db.version,
+		index: db.cloneIndex(),
+	}
+}
+
+// 克隆当前的内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) cloneIndex() index.Indexer {
+	// BTree 索引支持写时复制，无需拷贝全部数据
+	if bt, ok := db.index.(*index.BTree); ok {
+		return bt.Clone()
+	}
+
+	snapIndex := index.NewBTree()
+	it := db.index.Iterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		snapIndex.Put(it.Key(), it.Value())
+	}
+	return snapIndex
+}
+
+// SeqNo 快照对应的写入版本号，快照能看到版本号不大于它的所有写入
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 从快照中读取 key 对应的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	// 和 Release 一样先获取数据库的锁
+	s.db.mtx.RLock()
+	defer s.db.mtx.RUnlock()
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return s.db.getValueByPosition(logRecordPos)
+}
+
+// NewIterator 初始化快照上的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	// 快照已经释放，返回一个空的迭代器
+	if s.released {
+		return s.db.newIterator(index.NewBTree(), opts)
+	}
+	return s.db.newIterator(s.index, opts)
+}
+
+// Fold 遍历快照中的所有数据，并执行用户指定操作，函数返回 false 时终止遍历
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	s.db.mtx.RLock()
+	defer s.db.mtx.RUnlock()
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+
+	it := s.index.Iterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if it.Value().IsExpired() {
+			continue
+		}
+		value, err := s.db.getValueByPosition(it.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(it.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照持有的索引副本，可以重复调用
+func (s *Snapshot) Release() {
+	s.db.mtx.Lock()
+	defer s.db.mtx.Unlock()
+	s.release()
+}
+
+// 在访问此方法前必须持有数据库的互斥锁
+func (s *Snapshot) release() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.index = nil
+}
@@ -0,0 +1,110 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("v1"))
+		assert.Nil(t, err)
+	}
+
+	snap := db.Snapshot()
+	assert.Equal(t, uint64(100), snap.SeqNo())
+
+	// 创建快照之后的修改对快照不可见
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 50; i < 150; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("v2"))
+		assert.Nil(t, err)
+	}
+
+	val, err := snap.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	val, err = snap.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = snap.Get(utils.GetTestKey(120))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var count int
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	count = 0
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, []byte("v1"), value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	// 数据库本身能看到最新的数据
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	// 释放之后不能再读取
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.False(t, snap.NewIterator(DefaultIteratorOptions).Valid())
+}
+
+func TestDB_SnapshotDuringMerge(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-merge")
+	db, err := newTestMergeDB(dir)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v1")))
+	}
+	snap := db.Snapshot()
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v2")))
+	}
+
+	// 打开的快照和事务不会阻止 merge，merge 之后快照仍然可以读取旧的数据
+	txn := db.Begin()
+	assert.Nil(t, db.Merge())
+	val, err := snap.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	val, err = txn.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	txn.Rollback()
+	snap.Release()
+
+	assert.Nil(t, db.Close())
+	db, err = newTestMergeDB(dir)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
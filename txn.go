@@ -0,0 +1,423 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Txn 读写事务
+// 事务开始时创建一个快照，读操作优先读取事务内暂存的写入，其次读取快照。
+// 乐观事务提交时校验读写过的 key 在事务开始之后是否被其他写入修改过，如果有则提交失败；
+// 悲观事务在写入和 GetForUpdate 时获取 key 锁并持有到事务结束，提交时无需冲突检测
+type Txn struct {
+	db            *DB
+	mtx           *sync.Mutex
+	id            uint64 // 事务 id，用于悲观事务的 key 锁
+	options       TxnOptions
+	snap          *Snapshot                  // 事务开始时的快照
+	startVersion  uint64                     // 事务开始时的写入版本号
+	pendingWrites map[string]*data.LogRecord // 暂存事务内的写入
+	readSet       map[string]struct{}        // 事务内读取过的 key
+	finished      bool                       // 事务是否已经提交或回滚
+	readOnly      bool                       // 是否为只读事务
+}
+
+// Begin 开启一个乐观读写事务
+func (db *DB) Begin() *Txn {
+	return db.BeginTxn(DefaultTxnOptions)
+}
+
+// BeginTxn 根据配置项开启一个读写事务
+func (db *DB) BeginTxn(opts TxnOptions) *Txn {
+	if db.options.IndexType == BPTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file not exists")
+	}
+	return db.begin(opts, false)
+}
+
+// Update 在读写事务中执行 fn，fn 返回 nil 时提交事务，返回错误或者 panic 时回滚事务
+func (db *DB) Update(fn func(txn *Txn) error) error {
+	txn := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			txn.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return nil
+}
+
+// View 在只读事务中执行 fn，fn 读取到的是事务开始时的快照
+func (db *DB) View(fn func(txn *Txn) error) error {
+	txn := db.begin(DefaultTxnOptions, true)
+	defer txn.Rollback()
+	return fn(txn)
+}
+
+func (db *DB) begin(opts TxnOptions, readOnly bool) *Txn {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	snap := db.newSnapshot()
+	db.activeTxnNum++
+	return &Txn{
+		db:            db,
+		mtx:           new(sync.Mutex),
+		id:            atomic.AddUint64(&db.txnId, 1),
+		options:       opts,
+		snap:          snap,
+		startVersion:  snap.seqNo,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]struct{}),
+		readOnly:      readOnly,
+	}
+}
+
+// 记录 key 被修改的版本号，用于事务提交时的冲突检测
+// 在访问此方法前必须持有互斥锁
+func (db *DB) markModified(key []byte) {
+	if db.activeTxnNum > 0 {
+		db.modifiedKeys[string(key)] = db.version
+	}
+}
+
+// 事务结束，没有进行中的事务时清空修改记录
+// 在访问此方法前必须持有互斥锁
+func (db *DB) finishTxn() {
+	db.activeTxnNum--
+	if db.activeTxnNum == 0 {
+		db.modifiedKeys = make(map[string]uint64)
+	}
+}
+
+// Get 读取数据，优先读取事务内暂存的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.readSet[string(key)] = struct{}{}
+	return txn.snap.Get(key)
+}
+
+// Put 在事务内写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+	if err := txn.lockKey(key); err != nil {
+		return err
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 在事务内删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+	if err := txn.lockKey(key); err != nil {
+		return err
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// GetForUpdate 读取数据并锁定 key，直到事务提交或回滚才释放
+// 悲观事务读取的是加锁之后最新提交的数据，乐观事务等同于 Get
+func (txn *Txn) GetForUpdate(key []byte) ([]byte, error) {
+	if !txn.options.Pessimistic {
+		return txn.Get(key)
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+	if txn.readOnly {
+		return nil, ErrTxnReadOnly
+	}
+	if err := txn.lockKey(key); err != nil {
+		return nil, err
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+	return txn.db.Get(key)
+}
+
+// 悲观事务获取 key 锁，乐观事务无需加锁
+func (txn *Txn) lockKey(key []byte) error {
+	if !txn.options.Pessimistic {
+		return nil
+	}
+	return txn.db.lockManager.lock(txn.id, key, txn.options.LockWaitTimeout)
+}
+
+// Commit 提交事务，读写过的 key 在事务开始之后被修改过则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	// 无论提交成功与否，事务都结束了，先注册清理再进行校验
+	txn.finished = true
+	defer txn.db.lockManager.unlockAll(txn.id)
+
+	if txn.options.Pessimistic && len(txn.pendingWrites) > 0 &&
+		uint(len(txn.pendingWrites)) <= txn.options.MaxBatchNum {
+		return txn.commitPessimistic()
+	}
+
+	txn.db.mtx.Lock()
+	defer txn.db.mtx.Unlock()
+	defer txn.snap.release()
+	defer txn.db.finishTxn()
+
+	if uint(len(txn.pendingWrites)) > txn.options.MaxBatchNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 只读事务无需校验
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	// 冲突检测
+	for key := range txn.readSet {
+		if txn.db.modifiedKeys[key] > txn.startVersion {
+			return ErrTxnConflict
+		}
+	}
+	for key := range txn.pendingWrites {
+		if txn.db.modifiedKeys[key] > txn.startVersion {
+			return ErrTxnConflict
+		}
+	}
+
+	return txn.db.commitPendingWrites(txn.pendingWrites, txn.options.SyncWrites)
+}
+
+// 测试使用，悲观事务编码完成之后、写入数据文件之前调用
+var pessimisticCommitHook func(txn *Txn)
+
+// 提交悲观事务，事务已经持有所有写入 key 的锁，无需冲突检测。
+// 记录的编码和持久化都不持有数据库的锁，只在追加写入数据文件和更新索引时持有，不冲突的事务可以并行提交。
+// SyncWrites 时在释放锁之后才持久化，数据在持久化完成之前就可以被读取到
+func (txn *Txn) commitPessimistic() error {
+	db := txn.db
+	db.mtx.Lock()
+	if err := db.checkWritable(); err != nil {
+		txn.snap.release()
+		db.finishTxn()
+		db.mtx.Unlock()
+		return err
+	}
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	commitSeq, commitTime := db.nextCommit()
+	db.mtx.Unlock()
+
+	records := encodeTxnRecords(txn.pendingWrites, seqNo, commitSeq, commitTime)
+	if pessimisticCommitHook != nil {
+		pessimisticCommitHook(txn)
+	}
+
+	db.mtx.Lock()
+	positions, err := db.writeTxnRecords(records)
+	if err == nil {
+		db.publishPendingWrites(txn.pendingWrites, positions)
+	}
+	activeFile := db.activeFile
+	txn.snap.release()
+	db.finishTxn()
+	db.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 切换活跃文件时已经持久化了旧的文件，只需要持久化写入结束时的活跃文件
+	if txn.options.SyncWrites && activeFile != nil {
+		return activeFile.Sync()
+	}
+	return nil
+}
+
+// Rollback 回滚事务，丢弃所有暂存的写入，事务已经提交或者提交失败时不做任何处理
+func (txn *Txn) Rollback() {
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+	if txn.finished {
+		return
+	}
+	txn.finished = true
+	txn.pendingWrites = nil
+	txn.db.lockManager.unlockAll(txn.id)
+
+	txn.db.mtx.Lock()
+	defer txn.db.mtx.Unlock()
+	txn.snap.release()
+	txn.db.finishTxn()
+}
+
+// Iterator 初始化事务内的迭代器，合并事务内暂存的写入和快照中的数据
+func (txn *Txn) Iterator(opts IteratorOptions) *TxnIterator {
+	txn.mtx.Lock()
+	defer txn.mtx.Unlock()
+
+	var items []*txnIterItem
+	if !txn.finished {
+		items = txn.collectItems(opts)
+	}
+	return &TxnIterator{txn: txn, items: items, reverse: opts.Reverse}
+}
+
+// 合并快照和暂存的写入，按照 key 的顺序排列
+func (txn *Txn) collectItems(opts IteratorOptions) []*txnIterItem {
+	merged := make(map[string]*txnIterItem)
+	it := txn.snap.NewIterator(IteratorOptions{Prefix: opts.Prefix})
+	for it.Rewind(); it.Valid(); it.Next() {
+		merged[string(it.Key())] = &txnIterItem{key: it.Key(), pos: it.indexIter.Value()}
+	}
+	it.Close()
+
+	for key, record := range txn.pendingWrites {
+		if !bytes.HasPrefix(record.Key, opts.Prefix) {
+			continue
+		}
+		if record.Type == data.LogRecordDeleted {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = &txnIterItem{key: record.Key, value: record.Value, pending: true}
+	}
+
+	items := make([]*txnIterItem, 0, len(merged))
+	for _, item := range merged {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(items[i].key, items[j].key) > 0
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return items
+}
+
+type txnIterItem struct {
+	key     []byte
+	pos     *data.LogRecordPos // 快照中数据的位置
+	value   []byte             // 事务内暂存的数据
+	pending bool               // 是否为事务内暂存的数据
+}
+
+// TxnIterator 事务内的迭代器
+type TxnIterator struct {
+	txn       *Txn
+	items     []*txnIterItem
+	currIndex int
+	reverse   bool
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (ti *TxnIterator) Rewind() {
+	ti.currIndex = 0
+}
+
+// Seek 根据传入的 key 查找第一个大于(小于)等于的目标 key，根据从这个 key 开始遍历
+func (ti *TxnIterator) Seek(key []byte) {
+	ti.currIndex = sort.Search(len(ti.items), func(i int) bool {
+		if ti.reverse {
+			return bytes.Compare(ti.items[i].key, key) <= 0
+		}
+		return bytes.Compare(ti.items[i].key, key) >= 0
+	})
+}
+
+// Next 跳转到下一个 key
+func (ti *TxnIterator) Next() {
+	ti.currIndex++
+}
+
+// Valid 是否有效，即是否已经遍历完所有的 key，用于退出遍历
+func (ti *TxnIterator) Valid() bool {
+	return ti.currIndex < len(ti.items)
+}
+
+// Key 获取当前位置的 Key 数据
+func (ti *TxnIterator) Key() []byte {
+	return ti.items[ti.currIndex].key
+}
+
+// Value 获取当前位置的 Value 数据，读取的 key 会加入事务的读集合
+func (ti *TxnIterator) Value() ([]byte, error) {
+	item := ti.items[ti.currIndex]
+	if item.pending {
+		return item.value, nil
+	}
+
+	ti.txn.mtx.Lock()
+	ti.txn.readSet[string(item.key)] = struct{}{}
+	ti.txn.mtx.Unlock()
+
+	ti.txn.db.mtx.RLock()
+	defer ti.txn.db.mtx.RUnlock()
+	return ti.txn.db.getValueByPosition(item.pos)
+}
+
+// Close 关闭迭代器，释放相应的资源
+func (ti *TxnIterator) Close() {
+	ti.items = nil
+}
@@ -0,0 +1,390 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	// 读到自己的写入
+	err = txn.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 提交之前对外不可见
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 迭代器合并暂存的写入
+	var keys [][]byte
+	iter := txn.Iterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(3)}, keys)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重复提交
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 重启之后数据依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	// 读取的 key 在事务开始之后被修改，提交失败
+	txn1 := db.Begin()
+	txn2 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("2"))
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), []byte("3"))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 事务开始之后的普通写入同样会导致冲突
+	txn3 := db.Begin()
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(2), []byte("v"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("4"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 不相关的 key 互不影响
+	txn4 := db.Begin()
+	err = txn4.Put(utils.GetTestKey(5), []byte("v"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(6), []byte("v"))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+
+	// 回滚之后数据不会写入
+	txn5 := db.Begin()
+	err = txn5.Put(utils.GetTestKey(7), []byte("v"))
+	assert.Nil(t, err)
+	txn5.Rollback()
+	err = txn5.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+	_, err = db.Get(utils.GetTestKey(7))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, db.activeTxnNum)
+	assert.Equal(t, 0, len(db.modifiedKeys))
+}
+
+func TestDB_Txn_ExceedMaxBatchNum(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-6")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	txnOpts := DefaultTxnOptions
+	txnOpts.Pessimistic = true
+	txnOpts.MaxBatchNum = 1
+	txn := db.BeginTxn(txnOpts)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1), []byte("1")))
+	assert.Nil(t, txn.Put(utils.GetTestKey(2), []byte("2")))
+	assert.Equal(t, ErrExceedMaxBatchNum, txn.Commit())
+	assert.Equal(t, ErrTxnFinished, txn.Commit())
+	txn.Rollback()
+
+	// 提交失败的事务释放了持有的所有资源
+	assert.Equal(t, 0, db.activeTxnNum)
+	txn = db.BeginTxn(txnOpts)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1), []byte("1")))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, 0, len(db.modifiedKeys))
+}
+
+func TestDB_Update_View(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 返回 nil 时提交
+	err = db.Update(func(txn *Txn) error {
+		if err := txn.Put(utils.GetTestKey(1), []byte("v1")); err != nil {
+			return err
+		}
+		return txn.Put(utils.GetTestKey(2), []byte("v2"))
+	})
+	assert.Nil(t, err)
+
+	// 返回错误时回滚
+	errAbort := errors.New("abort")
+	err = db.Update(func(txn *Txn) error {
+		if err := txn.Put(utils.GetTestKey(3), []byte("v3")); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// panic 时回滚
+	assert.Panics(t, func() {
+		_ = db.Update(func(txn *Txn) error {
+			_ = txn.Put(utils.GetTestKey(4), []byte("v4"))
+			panic("something wrong")
+		})
+	})
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交失败时回滚
+	err = db.Update(func(txn *Txn) error {
+		if _, err := txn.Get(utils.GetTestKey(1)); err != nil {
+			return err
+		}
+		if err := db.Put(utils.GetTestKey(1), []byte("v1")); err != nil {
+			return err
+		}
+		return txn.Put(utils.GetTestKey(1), []byte("v6"))
+	})
+	assert.Equal(t, ErrTxnConflict, err)
+	assert.Equal(t, 0, db.activeTxnNum)
+
+	// 只读事务
+	err = db.View(func(txn *Txn) error {
+		val, err := txn.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		assert.Equal(t, ErrTxnReadOnly, txn.Put(utils.GetTestKey(5), []byte("v5")))
+		assert.Equal(t, ErrTxnReadOnly, txn.Delete(utils.GetTestKey(1)))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.activeTxnNum)
+}
+
+func TestDB_Txn_Pessimistic(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-4")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put([]byte("counter"), []byte("0"))
+	assert.Nil(t, err)
+
+	txnOpts := DefaultTxnOptions
+	txnOpts.Pessimistic = true
+	txnOpts.SyncWrites = false
+
+	// 并发对计数器加一，没有任何事务需要重试
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txn := db.BeginTxn(txnOpts)
+			val, err := txn.GetForUpdate([]byte("counter"))
+			assert.Nil(t, err)
+			n, _ := strconv.Atoi(string(val))
+			err = txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1)))
+			assert.Nil(t, err)
+			assert.Nil(t, txn.Commit())
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), val)
+
+	// 不冲突的事务可以同时持有各自的锁
+	txn1 := db.BeginTxn(txnOpts)
+	txn2 := db.BeginTxn(txnOpts)
+	assert.Nil(t, txn1.Put(utils.GetTestKey(1), []byte("v1")))
+	assert.Nil(t, txn2.Put(utils.GetTestKey(2), []byte("v2")))
+	assert.Nil(t, txn2.Commit())
+	assert.Nil(t, txn1.Commit())
+
+	// 等待锁超时
+	txnOpts.LockWaitTimeout = 50 * time.Millisecond
+	txn3 := db.BeginTxn(txnOpts)
+	txn4 := db.BeginTxn(txnOpts)
+	_, err = txn3.GetForUpdate(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn4.GetForUpdate(utils.GetTestKey(1))
+	assert.Equal(t, ErrLockWaitTimeout, err)
+	txn3.Rollback()
+	_, err = txn4.GetForUpdate(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	txn4.Rollback()
+}
+
+func TestDB_Txn_Deadlock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-5")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	txnOpts := DefaultTxnOptions
+	txnOpts.Pessimistic = true
+	txnOpts.LockWaitTimeout = 5 * time.Second
+
+	txn1 := db.BeginTxn(txnOpts)
+	txn2 := db.BeginTxn(txnOpts)
+	assert.Nil(t, txn1.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, txn2.Put([]byte("b"), []byte("2")))
+
+	// txn1 等待 txn2 持有的锁
+	done := make(chan error)
+	go func() {
+		done <- txn1.Put([]byte("b"), []byte("1"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// txn2 再等待 txn1 持有的锁会形成环，txn2 被选为牺牲者
+	err = txn2.Put([]byte("a"), []byte("2"))
+	assert.Equal(t, ErrTxnDeadlock, err)
+	txn2.Rollback()
+
+	assert.Nil(t, <-done)
+	assert.Nil(t, txn1.Commit())
+	val, err := db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestDB_Txn_PessimisticParallelCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-6")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	txnOpts := DefaultTxnOptions
+	txnOpts.Pessimistic = true
+	txn1 := db.BeginTxn(txnOpts)
+	txn2 := db.BeginTxn(txnOpts)
+	assert.Nil(t, txn1.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, txn2.Put([]byte("b"), []byte("2")))
+
+	// txn1 在提交的过程中暂停
+	paused := make(chan struct{})
+	resume := make(chan struct{})
+	pessimisticCommitHook = func(txn *Txn) {
+		if txn == txn1 {
+			close(paused)
+			<-resume
+		}
+	}
+	defer func() {
+		pessimisticCommitHook = nil
+	}()
+	done1 := make(chan error, 1)
+	go func() {
+		done1 <- txn1.Commit()
+	}()
+	<-paused
+
+	// 不冲突的 txn2 不需要等待 txn1 提交完成
+	done2 := make(chan error, 1)
+	go func() {
+		done2 <- txn2.Commit()
+	}()
+	select {
+	case err := <-done2:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("disjoint pessimistic txn blocked by another commit")
+	}
+	close(resume)
+	assert.Nil(t, <-done1)
+
+	val, err := db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+	val, err = db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	assert.Equal(t, 0, db.activeTxnNum)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -72,3 +73,38 @@ func CopyDir(src, dest string, exclude []string) error {
 		return os.WriteFile(filepath.Join(dest, filename), data, info.Mode())
 	})
 }
+
+// LinkOrCopyFile 通过硬链接创建文件，不支持硬链接时（例如跨文件系统）拷贝文件，返回是否为硬链接
+func LinkOrCopyFile(src, dest string) (bool, error) {
+	if err := os.Link(src, dest); err == nil {
+		return true, nil
+	}
+	return false, CopyFile(src, dest)
+}
+
+// CopyFile 拷贝文件并持久化，目标文件不能已经存在
+func CopyFile(src, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	if err := destFile.Sync(); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	return destFile.Close()
+}
@@ -0,0 +1,405 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/fio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gofrs/flock"
+)
+
+// VerifyIssue 校验数据目录时发现的一个问题
+type VerifyIssue struct {
+	File   string // 出现问题的文件名称
+	Offset int64  // 问题数据在文件中的位置
+	Size   int64  // 无法解析的字节数，0 表示不是数据损坏
+	Reason string
+}
+
+func (issue VerifyIssue) String() string {
+	if issue.Size > 0 {
+		return fmt.Sprintf("%s offset %d: %s, %d bytes", issue.File, issue.Offset, issue.Reason, issue.Size)
+	}
+	return fmt.Sprintf("%s offset %d: %s", issue.File, issue.Offset, issue.Reason)
+}
+
+// VerifyReport 数据目录的校验结果
+type VerifyReport struct {
+	DataFiles   int // 数据文件的数量
+	Records     int // 数据文件中可以正常解析的日志记录数量
+	HintRecords int // Hint 文件中的索引数量
+	// 没有事务完成记录的事务中的记录数量，写入过程中崩溃时会出现，打开数据库时会被丢弃
+	IncompleteTxnRecords int
+	PendingMerge         bool // merge 目录中有已经完成的 merge 结果，下次打开数据库时应用
+	Issues               []VerifyIssue
+}
+
+// OK 数据目录中没有发现问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *VerifyReport) addIssue(file string, offset, size int64, format string, args ...any) {
+	r.Issues = append(r.Issues, VerifyIssue{File: file, Offset: offset, Size: size, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Verify 离线校验数据目录，数据库不能处于打开状态
+// 使用 DataFile.ReadLogRecord 读取所有的数据文件、Hint 文件、merge 完成标识文件和事务序列号文件，报告 CRC 校验失败和不完整的记录，
+// 损坏的数据之后从下一条有效的记录继续校验。同时检查 Hint 文件中的索引是否指向数据文件中对应的记录，以及是否有没有完成的 merge 目录
+func Verify(dirPath string) (*VerifyReport, error) {
+	filelock, err := lockDataDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer filelock.Unlock()
+
+	v, err := newVerifier(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer v.close()
+	if err := v.verify(); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// Repair 使用数据目录中所有有效的记录在 outDir 中重新生成数据目录，outDir 必须不存在，返回修复之前的校验结果
+// 数据文件保持原来的文件 id，跳过损坏的数据和没有提交的事务中的记录，不生成 Hint 文件和 merge 完成标识文件，
+// 打开修复之后的数据目录时从数据文件中重建索引。B+ 树索引文件不会拷贝，需要使用内存索引打开
+func Repair(dirPath, outDir string) (*VerifyReport, error) {
+	filelock, err := lockDataDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer filelock.Unlock()
+
+	if _, err := os.Stat(outDir); err == nil {
+		return nil, ErrRepairDirExists
+	}
+
+	v, err := newVerifier(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer v.close()
+	if err := v.verify(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := v.rewrite(outDir); err != nil {
+		_ = os.RemoveAll(outDir)
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// 获取数据目录的文件锁，保证校验和修复时没有其他进程在写入
+func lockDataDir(dirPath string) (*flock.Flock, error) {
+	if _, err := os.Stat(dirPath); err != nil {
+		return nil, err
+	}
+	filelock := flock.New(filepath.Join(dirPath, fileLockName))
+	hold, err := filelock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	return filelock, nil
+}
+
+type verifier struct {
+	dirPath   string
+	fileIds   []uint32
+	dataFiles map[uint32]*data.DataFile
+	report    *VerifyReport
+
+	committedTxns map[uint64]struct{} // 读取到事务完成记录的事务序列号
+	seqNo         uint64              // 数据文件和事务序列号文件中最大的事务序列号
+}
+
+func newVerifier(dirPath string) (*verifier, error) {
+	files, err := listDataFiles(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	v := &verifier{
+		dirPath:       dirPath,
+		dataFiles:     make(map[uint32]*data.DataFile),
+		report:        &VerifyReport{DataFiles: len(files)},
+		committedTxns: make(map[uint64]struct{}),
+	}
+	for _, file := range files {
+		dataFile, err := data.OpenDataFileWithName(file.fileName, file.fid, fio.StandardIO)
+		if err != nil {
+			v.close()
+			return nil, err
+		}
+		v.fileIds = append(v.fileIds, file.fid)
+		v.dataFiles[file.fid] = dataFile
+	}
+	return v, nil
+}
+
+func (v *verifier) close() {
+	for _, dataFile := range v.dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+func (v *verifier) verify() error {
+	txnRecords := make(map[uint64]int)
+	for _, fid := range v.fileIds {
+		err := v.scanFile(v.dataFiles[fid], filepath.Base(data.GetDataFileName(v.dirPath, fid)),
+			func(logRecord *data.LogRecord, offset int64, size int64) error {
+				v.report.Records++
+				_, seqNo := parseLogRecordKey(logRecord.Key)
+				if seqNo == nonTransactionSeqNo {
+					return nil
+				}
+				v.seqNo = max(v.seqNo, seqNo)
+				if logRecord.Type == data.LogRecordTxnFinished {
+					v.committedTxns[seqNo] = struct{}{}
+					delete(txnRecords, seqNo)
+				} else {
+					txnRecords[seqNo]++
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	for _, count := range txnRecords {
+		v.report.IncompleteTxnRecords += count
+	}
+
+	nonMergeFileId, hasMerge, err := v.verifyMergeFinishedFile(v.dirPath, data.MergeFinishedFileName)
+	if err != nil {
+		return err
+	}
+	if err := v.verifyHintFile(nonMergeFileId, hasMerge); err != nil {
+		return err
+	}
+	if err := v.verifySeqNoFile(); err != nil {
+		return err
+	}
+	return v.verifyMergeDir()
+}
+
+// 顺序读取文件中的日志记录，对每条有效的记录调用 fn，无法解析的数据记录到校验结果中
+func (v *verifier) scanFile(logFile *data.DataFile, name string, fn func(logRecord *data.LogRecord, offset int64, size int64) error) error {
+	return scanLogFile(logFile, fn, func(offset int64, size int64, reason string) {
+		v.report.addIssue(name, offset, size, reason)
+	})
+}
+
+// 顺序读取文件中的日志记录，对每条有效的记录调用 fn，遇到无法解析的数据时调用 onCorrupted，并从下一条有效的记录继续读取
+func scanLogFile(logFile *data.DataFile, fn func(logRecord *data.LogRecord, offset int64, size int64) error,
+	onCorrupted func(offset int64, size int64, reason string)) error {
+	fileSize, err := logFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for offset < fileSize {
+		logRecord, size, err := logFile.ReadLogRecord(offset)
+		if err == nil {
+			if err := fn(logRecord, offset, size); err != nil {
+				return err
+			}
+			offset += size
+			continue
+		}
+		if err != io.EOF && err != data.ErrInvalidCRC {
+			return err
+		}
+
+		next, findErr := findNextLogRecord(logFile, offset+1, fileSize)
+		if findErr != nil {
+			return findErr
+		}
+		if next < 0 {
+			next = fileSize
+		}
+		if onCorrupted != nil {
+			switch {
+			case err == data.ErrInvalidCRC:
+				onCorrupted(offset, next-offset, "invalid crc")
+			case next == fileSize:
+				onCorrupted(offset, next-offset, "incomplete record at the end of the file")
+			default:
+				onCorrupted(offset, next-offset, "record can not be decoded")
+			}
+		}
+		offset = next
+	}
+	return nil
+}
+
+// 校验 merge 完成标识文件，返回最近没有参与 merge 的文件 id，name 为校验结果中使用的文件名称
+func (v *verifier) verifyMergeFinishedFile(dirPath, name string) (uint32, bool, error) {
+	logFile, ok, err := openLogFile(dirPath, data.MergeFinishedFileName, data.OpenMergeFinishedFile)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	defer logFile.Close()
+
+	var nonMergeFileId uint32
+	var found bool
+	err = v.scanFile(logFile, name, func(logRecord *data.LogRecord, offset int64, size int64) error {
+		if found {
+			return nil
+		}
+		fid, err := strconv.ParseUint(string(logRecord.Value), 10, 32)
+		if string(logRecord.Key) != mergeFinishedKey || err != nil {
+			v.report.addIssue(name, offset, 0, "invalid merge finished record")
+			return nil
+		}
+		nonMergeFileId, found = uint32(fid), true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if !found {
+		v.report.addIssue(name, 0, 0, "no valid merge finished record")
+	}
+	return nonMergeFileId, found, nil
+}
+
+// 校验 Hint 文件中的每条索引都指向 merge 重写的数据文件中 key 相同的记录
+func (v *verifier) verifyHintFile(nonMergeFileId uint32, hasMerge bool) error {
+	logFile, ok, err := openLogFile(v.dirPath, data.HintFileName, data.OpenHintFile)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if hasMerge {
+			v.report.addIssue(data.MergeFinishedFileName, 0, 0,
+				"hint file is missing, the index of files before %d can not be loaded", nonMergeFileId)
+		}
+		return nil
+	}
+	defer logFile.Close()
+	if !hasMerge {
+		v.report.addIssue(data.HintFileName, 0, 0, "merge finished file is missing")
+	}
+
+	return v.scanFile(logFile, data.HintFileName, func(logRecord *data.LogRecord, offset int64, size int64) error {
+		v.report.HintRecords++
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		dataFile, ok := v.dataFiles[pos.Fid]
+		if !ok {
+			v.report.addIssue(data.HintFileName, offset, 0, "data file %d is missing", pos.Fid)
+			return nil
+		}
+		if hasMerge && pos.Fid >= nonMergeFileId {
+			v.report.addIssue(data.HintFileName, offset, 0, "data file %d is not rewritten by merge", pos.Fid)
+			return nil
+		}
+		record, recordSize, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil {
+			v.report.addIssue(data.HintFileName, offset, 0, "file %d offset %d: %v", pos.Fid, pos.Offset, err)
+			return nil
+		}
+		key, _ := parseLogRecordKey(record.Key)
+		if string(key) != string(logRecord.Key) || recordSize != int64(pos.Size) {
+			v.report.addIssue(data.HintFileName, offset, 0, "file %d offset %d does not match the hint record", pos.Fid, pos.Offset)
+		}
+		return nil
+	})
+}
+
+func (v *verifier) verifySeqNoFile() error {
+	logFile, ok, err := openLogFile(v.dirPath, data.SeqNoFileName, data.OpenSeqNoFile)
+	if err != nil || !ok {
+		return err
+	}
+	defer logFile.Close()
+
+	return v.scanFile(logFile, data.SeqNoFileName, func(logRecord *data.LogRecord, offset int64, size int64) error {
+		seqNo, err := strconv.ParseUint(string(logRecord.Value), 10, 64)
+		if err != nil {
+			v.report.addIssue(data.SeqNoFileName, offset, 0, "invalid sequence number %q", logRecord.Value)
+			return nil
+		}
+		v.seqNo = max(v.seqNo, seqNo)
+		return nil
+	})
+}
+
+// 检查 merge 目录，没有 merge 完成标识文件说明 merge 过程中崩溃，下次打开时会被删除
+func (v *verifier) verifyMergeDir() error {
+	mergePath := mergeDirPath(v.dirPath)
+	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+		return nil
+	}
+	name := filepath.Base(mergePath)
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err != nil {
+		v.report.addIssue(name, 0, 0, "merge did not finish, the directory will be removed at the next open")
+		return nil
+	}
+
+	_, ok, err := v.verifyMergeFinishedFile(mergePath, filepath.Join(name, data.MergeFinishedFileName))
+	if err != nil {
+		return err
+	}
+	v.report.PendingMerge = ok
+	return nil
+}
+
+// 打开数据目录中的文件，文件不存在时返回 false，避免创建新的文件
+func openLogFile(dirPath, name string, open func(dirPath string) (*data.DataFile, error)) (*data.DataFile, bool, error) {
+	if _, err := os.Stat(filepath.Join(dirPath, name)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	logFile, err := open(dirPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return logFile, true, nil
+}
+
+// 将有效的记录写入到 outDir 中相同 id 的数据文件中
+func (v *verifier) rewrite(outDir string) error {
+	for _, fid := range v.fileIds {
+		outFile, err := data.OpenDataFile(outDir, fid, fio.StandardIO)
+		if err != nil {
+			return err
+		}
+		// 损坏的数据已经在校验时记录，这里直接跳过
+		err = scanLogFile(v.dataFiles[fid], func(logRecord *data.LogRecord, offset int64, size int64) error {
+			_, seqNo := parseLogRecordKey(logRecord.Key)
+			if seqNo != nonTransactionSeqNo {
+				if _, ok := v.committedTxns[seqNo]; !ok {
+					return nil
+				}
+			}
+			encRecord, _ := data.EncodeLogRecord(logRecord)
+			return outFile.Write(encRecord)
+		}, nil)
+		if err == nil {
+			err = outFile.Sync()
+		}
+		if closeErr := outFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writeSeqNoFile(outDir, v.seqNo)
+}
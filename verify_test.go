@@ -0,0 +1,120 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bitcask-kv/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 数据库打开时不能校验
+	_, err = Verify(dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 已经完成但还没有应用的 merge
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.True(t, report.PendingMerge)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("after merge"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Issues)
+	assert.False(t, report.PendingMerge)
+	assert.Equal(t, 400, report.HintRecords)
+
+	// 没有完成的 merge 目录
+	mergePath := mergeDirPath(dir)
+	assert.Nil(t, os.Mkdir(mergePath, os.ModePerm))
+	defer os.RemoveAll(mergePath)
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Issues))
+	assert.Equal(t, filepath.Base(mergePath), report.Issues[0].File)
+}
+
+func TestRepair(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 损坏数据文件中的一条记录，并追加一个没有提交的事务
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted data"), 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithReq([]byte("uncommitted"), 99),
+		Value: []byte("value"),
+	})
+	appendToDataFile(t, dir, db.activeFile.FileId, encRecord)
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Issues))
+	issue := report.Issues[0]
+	assert.Equal(t, "000000000.data", issue.File)
+	assert.Equal(t, "invalid crc", issue.Reason)
+	assert.True(t, issue.Offset <= 1000 && issue.Offset+issue.Size > 1000)
+	assert.Equal(t, 1, report.IncompleteTxnRecords)
+
+	outDir := dir + "-repaired"
+	defer os.RemoveAll(outDir)
+	_, err = Repair(dir, outDir)
+	assert.Nil(t, err)
+	_, err = Repair(dir, outDir)
+	assert.Equal(t, ErrRepairDirExists, err)
+
+	report, err = Verify(outDir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Issues)
+	assert.Equal(t, 0, report.IncompleteTxnRecords)
+
+	// 修复之后的数据目录可以直接打开
+	repairedOpts := opts
+	repairedOpts.DirPath = outDir
+	repaired, err := Open(repairedOpts)
+	assert.Nil(t, err)
+	keys := len(repaired.ListKeys())
+	assert.True(t, keys < 500 && keys >= 495)
+	assert.Nil(t, repaired.Put([]byte("after repair"), []byte("value")))
+	assert.Nil(t, repaired.Close())
+}
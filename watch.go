@@ -0,0 +1,277 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/data"
+	"bytes"
+	"context"
+	"io"
+)
+
+const (
+	// Watch 事件通道的缓冲大小
+	watchChanSize = 128
+	// Watch 协程每次持有读锁时最多读取的记录数量，避免长时间阻塞写入
+	maxWatchRecordsPerRead = 1024
+)
+
+// WatchEventType 变更事件的类型
+type WatchEventType byte
+
+const (
+	// WatchEventPut 写入数据，Value 为写入的值
+	WatchEventPut WatchEventType = iota
+	// WatchEventDelete 删除数据，包括 key 过期之后由后台清理写入的删除
+	WatchEventDelete
+	// WatchEventDeleteRange 范围删除，Key 为范围起点，Value 为范围终点（不包含），为空表示没有上界
+	WatchEventDeleteRange
+	// WatchEventTxnCommit 批量写或事务提交，在该事务所有的写入事件之后投递，Key 和 Value 为空
+	WatchEventTxnCommit
+)
+
+// WatchEvent 数据变更事件
+type WatchEvent struct {
+	Type   WatchEventType
+	Key    []byte
+	Value  []byte
+	Expire int64 // 过期时间，UnixNano，0 表示永不过期
+	// Seq 事件的序列号，按写入顺序严格递增，并且在重启之后保持不变
+	// 将最后处理完成的 Seq 传给 WatchFrom 即可从中断的位置继续订阅
+	Seq uint64
+}
+
+// Watch 序列号中偏移占用的位数，数据文件的大小不能超过此范围
+const watchSeqOffsetBits = 32
+
+// 根据日志记录在数据文件中的结束位置计算序列号
+// 高 32 位为文件 id，低 32 位为记录结束的偏移，0 表示数据文件的起点，checkOptions 保证偏移不会超过 32 位
+func watchSeq(fid uint32, offset int64) uint64 {
+	return uint64(fid)<<32 | uint64(offset)
+}
+
+func parseWatchSeq(seq uint64) (uint32, int64) {
+	return uint32(seq >> 32), int64(seq & 0xffffffff)
+}
+
+// Watch 订阅 key 前缀为 prefix 的数据变更，prefix 为空时订阅所有数据，只投递订阅之后的写入
+// 事件按照写入的顺序投递，批量写和事务的写入在提交之后才会投递。
+// ctx 取消、数据库关闭或者读取数据文件出错时通道会被关闭，消费者处理过慢时不会阻塞写入，事件会在消费时从数据文件中读取
+func (db *DB) Watch(ctx context.Context, prefix []byte) (<-chan *WatchEvent, error) {
+	db.mtx.RLock()
+	var seq uint64
+	if db.activeFile != nil {
+		seq = watchSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+	}
+	db.mtx.RUnlock()
+	return db.WatchFrom(ctx, prefix, seq)
+}
+
+// WatchFrom 从序列号 seq 之后继续订阅数据变更，会先从数据文件中重放 seq 之后的历史写入
+// seq 为 0 时从头重放所有的数据文件。seq 对应的数据已经被 merge 重写时返回 ErrWatchSeqCompacted，
+// 此时消费者需要通过 Fold 等方式重新全量同步，再通过 Watch 订阅
+func (db *DB) WatchFrom(ctx context.Context, prefix []byte, seq uint64) (<-chan *WatchEvent, error) {
+	fid, offset := parseWatchSeq(seq)
+
+	db.mtx.RLock()
+	if seq != 0 && fid < db.mergeBoundary {
+		db.mtx.RUnlock()
+		return nil, ErrWatchSeqCompacted
+	}
+	if seq != 0 && (db.activeFile == nil || fid > db.activeFile.FileId ||
+		(fid == db.activeFile.FileId && offset > db.activeFile.WriteOff)) {
+		db.mtx.RUnlock()
+		return nil, ErrInvalidWatchSeq
+	}
+	db.mtx.RUnlock()
+
+	w := &watcher{
+		db:      db,
+		prefix:  prefix,
+		fid:     fid,
+		offset:  offset,
+		txns:    make(map[uint64][]*WatchEvent),
+		eventCh: make(chan *WatchEvent, watchChanSize),
+	}
+	go w.run(ctx)
+	return w.eventCh, nil
+}
+
+// 唤醒等待新写入的 Watch 协程
+// 在访问此方法前必须持有互斥锁
+func (db *DB) notifyWatchers() {
+	db.watchMtx.Lock()
+	defer db.watchMtx.Unlock()
+	if db.watchNotify != nil {
+		close(db.watchNotify)
+		db.watchNotify = nil
+	}
+}
+
+// 获取一个在下次写入时关闭的通道
+// 在访问此方法前必须持有读锁或互斥锁，保证获取之后的写入一定会关闭该通道
+func (db *DB) watchNotifyChan() <-chan struct{} {
+	db.watchMtx.Lock()
+	defer db.watchMtx.Unlock()
+	if db.watchNotify == nil {
+		db.watchNotify = make(chan struct{})
+	}
+	return db.watchNotify
+}
+
+// 一个 Watch 订阅，顺序读取数据文件并转换为变更事件
+type watcher struct {
+	db      *DB
+	prefix  []byte
+	fid     uint32                   // 下一条待读取记录所在的文件 id
+	offset  int64                    // 下一条待读取记录在文件中的偏移
+	txns    map[uint64][]*WatchEvent // 暂存未提交的事务中的事件
+	eventCh chan *WatchEvent
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.eventCh)
+	for {
+		events, notify, err := w.read()
+		if err != nil {
+			return
+		}
+		for _, event := range events {
+			select {
+			case w.eventCh <- event:
+			case <-ctx.Done():
+				return
+			case <-w.db.closeChan:
+				return
+			}
+		}
+		// 已经读取到最新的位置，等待新的写入
+		if notify != nil {
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			case <-w.db.closeChan:
+				return
+			}
+		}
+	}
+}
+
+// 从当前位置读取一批记录，并转换为事件
+// 如果已经读取到最新的位置，返回一个在下次写入时关闭的通道
+func (w *watcher) read() ([]*WatchEvent, <-chan struct{}, error) {
+	db := w.db
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	// 数据库已经关闭，数据文件可能已经被关闭
+	select {
+	case <-db.closeChan:
+		return nil, nil, io.EOF
+	default:
+	}
+
+	var events []*WatchEvent
+	for i := 0; i < maxWatchRecordsPerRead; i++ {
+		if db.activeFile == nil {
+			return events, db.watchNotifyChan(), nil
+		}
+
+		var dataFile *data.DataFile
+		if w.fid == db.activeFile.FileId {
+			dataFile = db.activeFile
+		} else {
+			dataFile = db.olderFiles[w.fid]
+		}
+
+		// 当前文件已经读取完毕，或者文件不存在，跳到下一个文件
+		if dataFile == nil || (dataFile != db.activeFile && w.offset >= w.fileSize(dataFile)) {
+			next, ok := db.nextDataFileId(w.fid)
+			if !ok {
+				return events, db.watchNotifyChan(), nil
+			}
+			w.fid, w.offset = next, 0
+			continue
+		}
+		if dataFile == db.activeFile && w.offset >= db.activeFile.WriteOff {
+			return events, db.watchNotifyChan(), nil
+		}
+
+		logRecord, size, err := dataFile.ReadLogRecord(w.offset)
+		if err != nil {
+			if err == io.EOF {
+				// 旧的数据文件已经读取完毕
+				if dataFile == db.activeFile {
+					return events, db.watchNotifyChan(), nil
+				}
+				w.offset = w.fileSize(dataFile)
+				continue
+			}
+			return nil, nil, err
+		}
+		w.offset += size
+		events = append(events, w.handleRecord(logRecord, watchSeq(w.fid, w.offset))...)
+	}
+	return events, nil, nil
+}
+
+func (w *watcher) fileSize(dataFile *data.DataFile) int64 {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// 将一条日志记录转换为事件，事务中的记录暂存到提交时再投递
+func (w *watcher) handleRecord(logRecord *data.LogRecord, seq uint64) []*WatchEvent {
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+
+	if logRecord.Type == data.LogRecordTxnFinished {
+		events := w.txns[seqNo]
+		delete(w.txns, seqNo)
+		if len(events) == 0 {
+			return nil
+		}
+		return append(events, &WatchEvent{Type: WatchEventTxnCommit, Seq: seq})
+	}
+
+	var event *WatchEvent
+	switch logRecord.Type {
+	case data.LogRecordNormal:
+		if !bytes.HasPrefix(realKey, w.prefix) {
+			return nil
+		}
+		event = &WatchEvent{Type: WatchEventPut, Key: realKey, Value: logRecord.Value, Expire: logRecord.Expire}
+	case data.LogRecordDeleted:
+		if !bytes.HasPrefix(realKey, w.prefix) {
+			return nil
+		}
+		event = &WatchEvent{Type: WatchEventDelete, Key: realKey}
+	case data.LogRecordRangeDeleted:
+		if !w.overlapsPrefix(realKey, logRecord.Value) {
+			return nil
+		}
+		event = &WatchEvent{Type: WatchEventDeleteRange, Key: realKey, Value: logRecord.Value}
+	default:
+		return nil
+	}
+	event.Seq = seq
+
+	if seqNo == nonTransactionSeqNo {
+		return []*WatchEvent{event}
+	}
+	w.txns[seqNo] = append(w.txns[seqNo], event)
+	return nil
+}
+
+// 范围 [start, end) 是否和订阅的前缀范围有交集
+func (w *watcher) overlapsPrefix(start, end []byte) bool {
+	if len(w.prefix) == 0 {
+		return true
+	}
+	if len(end) > 0 && bytes.Compare(end, w.prefix) <= 0 {
+		return false
+	}
+	upper := prefixUpperBound(w.prefix)
+	return upper == nil || bytes.Compare(start, upper) < 0
+}
@@ -0,0 +1,146 @@
+package bitcask_kv
+
+import (
+	"bitcask-kv/utils"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveEvent(t *testing.T, ch <-chan *WatchEvent) *WatchEvent {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second * 3):
+		t.Fatal("timeout waiting for watch event")
+	}
+	return nil
+}
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 订阅之前的写入不会投递
+	err = db.Put([]byte("user:0"), []byte("before"))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := db.Watch(ctx, []byte("user:"))
+	assert.Nil(t, err)
+
+	err = db.Put([]byte("user:1"), []byte("v1"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("order:1"), []byte("ignored"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user:1"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("user:2"), []byte("v2"))
+	_ = wb.Put([]byte("order:2"), []byte("ignored"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	err = db.DeletePrefix([]byte("user:"))
+	assert.Nil(t, err)
+
+	e1 := receiveEvent(t, ch)
+	assert.Equal(t, WatchEventPut, e1.Type)
+	assert.Equal(t, []byte("user:1"), e1.Key)
+	assert.Equal(t, []byte("v1"), e1.Value)
+
+	e2 := receiveEvent(t, ch)
+	assert.Equal(t, WatchEventDelete, e2.Type)
+	assert.Equal(t, []byte("user:1"), e2.Key)
+	assert.True(t, e2.Seq > e1.Seq)
+
+	e3 := receiveEvent(t, ch)
+	assert.Equal(t, WatchEventPut, e3.Type)
+	assert.Equal(t, []byte("user:2"), e3.Key)
+	e4 := receiveEvent(t, ch)
+	assert.Equal(t, WatchEventTxnCommit, e4.Type)
+	assert.True(t, e4.Seq > e3.Seq)
+
+	e5 := receiveEvent(t, ch)
+	assert.Equal(t, WatchEventDeleteRange, e5.Type)
+	assert.Equal(t, []byte("user:"), e5.Key)
+
+	// 取消订阅之后通道关闭
+	cancel()
+	for range ch {
+	}
+
+	// 从 e2 之后重放
+	ch2, err := db.WatchFrom(context.Background(), []byte("user:"), e2.Seq)
+	assert.Nil(t, err)
+	assert.Equal(t, e3, receiveEvent(t, ch2))
+	assert.Equal(t, e4, receiveEvent(t, ch2))
+	assert.Equal(t, e5, receiveEvent(t, ch2))
+
+	// 关闭数据库之后通道关闭
+	err = db.Close()
+	assert.Nil(t, err)
+	_, ok := <-ch2
+	assert.False(t, ok)
+
+	// 重启之后继续从 e2 之后重放，序列号保持不变
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db2.Close() }()
+	ch3, err := db2.WatchFrom(context.Background(), nil, e2.Seq)
+	assert.Nil(t, err)
+	// 批量写入的事件在提交之后一起投递，顺序和写入数据文件的顺序一致
+	txnKeys := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := receiveEvent(t, ch3)
+		assert.Equal(t, WatchEventPut, e.Type)
+		txnKeys[string(e.Key)] = true
+	}
+	assert.Equal(t, map[string]bool{"user:2": true, "order:2": true}, txnKeys)
+	assert.Equal(t, e4, receiveEvent(t, ch3))
+	assert.Equal(t, e5, receiveEvent(t, ch3))
+
+	_, err = db2.WatchFrom(context.Background(), nil, watchSeq(100, 0))
+	assert.Equal(t, ErrInvalidWatchSeq, err)
+}
+
+func TestDB_Watch_FileRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-rotation")
+	opts.DirPath = dir
+	// 序列号中的偏移只有 32 位
+	opts.DataFileSize = 4 * 1024 * 1024 * 1024
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ch, err := db.WatchFrom(context.Background(), nil, 0)
+	assert.Nil(t, err)
+
+	go func() {
+		for i := 0; i < 1000; i++ {
+			_ = db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		}
+	}()
+
+	var lastSeq uint64
+	for i := 0; i < 1000; i++ {
+		event := receiveEvent(t, ch)
+		assert.Equal(t, utils.GetTestKey(i), event.Key)
+		assert.True(t, event.Seq > lastSeq)
+		lastSeq = event.Seq
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+}